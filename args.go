@@ -0,0 +1,126 @@
+package depends
+
+import (
+	"reflect"
+)
+
+// positionalArg is an argument handed to InjectWith for a specific position.
+type positionalArg struct {
+	pos   int
+	value interface{}
+}
+
+// At wraps a value handed to InjectWith or TryInjectWith so that it is used for
+// the argument at the position given (1 indexed), regardless of its type. This
+// is useful when several arguments share a type, or to hand over a nil value.
+func At(pos int, value interface{}) interface{} {
+	if pos < 1 {
+		panic("At expects a position of 1 or more")
+	}
+	return positionalArg{pos, value}
+}
+
+// InjectWith injects the dependencies asked for into the function provided in the
+// same way as Inject, except that the arguments given are used in preference to
+// anything registered. Each argument is handed to the first argument of the function
+// with a matching type that isn't already taken, unless it is wrapped with At, in
+// which case it is handed to the argument at that position. If anything goes wrong,
+// it will panic.
+func (ctx *Context) InjectWith(fn interface{}, args ...interface{}) {
+	err := ctx.TryInjectWith(fn, args...)
+
+	if err != nil {
+		panic(err.Error())
+	}
+}
+
+// TryInjectWith injects the dependencies asked for into the function provided in the
+// same way as TryInject, except that the arguments given are used in preference to
+// anything registered, as with InjectWith. If an argument can't be handed to the
+// function, an ErrorArgumentNotUsed is returned and the function is not called.
+func (ctx *Context) TryInjectWith(fn interface{}, args ...interface{}) error {
+	fnVal := reflect.ValueOf(fn)
+	if fnVal.Kind() != reflect.Func {
+		return ErrorFunctionNotProvided{}
+	}
+
+	supplied, err := supplyArgs(fnVal.Type(), args)
+	if err != nil {
+		return err
+	}
+
+	out, err := ctx.injectIntoFunction(newResolution(nil), supplied, fnVal)
+	if err != nil {
+		return err
+	}
+	_, err = splitResults(fnVal.Type(), out)
+	return err
+}
+
+// supplyArgs works out which arguments of a function with the type given each of
+// args should be handed to. Positional arguments take precedence, and then the rest
+// are matched by type, preferring an exact match over one that is assignable.
+func supplyArgs(fnTy reflect.Type, args []interface{}) ([]reflect.Value, error) {
+	supplied := make([]reflect.Value, fnTy.NumIn())
+
+	var byType []interface{}
+	for _, arg := range args {
+		pa, ok := arg.(positionalArg)
+		if !ok {
+			byType = append(byType, arg)
+			continue
+		}
+		i := pa.pos - 1
+		if i >= len(supplied) || supplied[i].IsValid() {
+			return nil, ErrorArgumentNotUsed{Ty: reflect.TypeOf(pa.value), Pos: pa.pos}
+		}
+		val, ok := argValue(fnTy.In(i), pa.value)
+		if !ok {
+			return nil, ErrorArgumentNotUsed{Ty: reflect.TypeOf(pa.value), Pos: pa.pos}
+		}
+		supplied[i] = val
+	}
+
+	for _, arg := range byType {
+		argTy := reflect.TypeOf(arg)
+		i := firstFree(fnTy, supplied, func(ty reflect.Type) bool { return argTy == ty })
+		if i < 0 && argTy != nil {
+			i = firstFree(fnTy, supplied, argTy.AssignableTo)
+		}
+		if i < 0 {
+			return nil, ErrorArgumentNotUsed{Ty: argTy}
+		}
+		supplied[i] = reflect.ValueOf(arg)
+	}
+
+	return supplied, nil
+}
+
+// firstFree returns the index of the first argument of fnTy which hasn't been
+// supplied and whose type matches, or -1 if there is none.
+func firstFree(fnTy reflect.Type, supplied []reflect.Value, matches func(reflect.Type) bool) int {
+	for i := range supplied {
+		if !supplied[i].IsValid() && matches(fnTy.In(i)) {
+			return i
+		}
+	}
+	return -1
+}
+
+// argValue converts value into something that can be handed to an argument
+// of the type given, returning false if this isn't possible.
+func argValue(ty reflect.Type, value interface{}) (reflect.Value, bool) {
+	if value == nil {
+		switch ty.Kind() {
+		case reflect.Chan, reflect.Func, reflect.Interface, reflect.Map, reflect.Ptr, reflect.Slice:
+			return reflect.Zero(ty), true
+		default:
+			return reflect.Value{}, false
+		}
+	}
+	val := reflect.ValueOf(value)
+	if !val.Type().AssignableTo(ty) {
+		return reflect.Value{}, false
+	}
+	return val, true
+}
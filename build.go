@@ -0,0 +1,174 @@
+package depends
+
+import (
+	"context"
+)
+
+// Build creates every value registered on the Context (or its parents) up front,
+// by calling the registered functions that have not been called yet, rather than
+// waiting for each value to be asked for. Values are created from the point of view
+// of this Context, so Scoped functions create values belonging to it, Transient
+// functions are not called at all, and registrations on parents which have been
+// overridden by this Context (or a closer parent) are left alone unless
+// something else depends on them.
+//
+// Values which don't depend on each other are created concurrently, with at most
+// parallelism registered functions running at once (values below 1 mean 1). As
+// soon as something fails, no more functions are started, and Build returns once
+// those already running have finished. If the context.Context provided is done
+// before everything has been created, Build returns straight away without waiting.
+// Registered functions which ask for a context.Context are handed c.
+// In either case, every error encountered is handed back in an ErrorBuildFailed.
+func (ctx *Context) Build(c context.Context, parallelism int) error {
+	if parallelism < 1 {
+		parallelism = 1
+	}
+
+	nodes := ctx.buildNodes()
+	results := make(chan buildResult, len(nodes))
+	errs := []error{}
+	ready := []*buildNode{}
+	running := 0
+	failed := false
+
+	for _, node := range nodes {
+		if node.waitingOn == 0 {
+			ready = append(ready, node)
+		}
+	}
+
+	for {
+		for len(ready) > 0 && running < parallelism && !failed {
+			if err := c.Err(); err != nil {
+				return ErrorBuildFailed{append(errs, err)}
+			}
+			node := ready[0]
+			ready = ready[1:]
+			running++
+			go func() {
+				results <- buildResult{node, node.build(c)}
+			}()
+		}
+		if running == 0 {
+			break
+		}
+
+		select {
+		case res := <-results:
+			running--
+			res.node.built = true
+			if res.err != nil {
+				errs = append(errs, res.err)
+				failed = true
+				continue
+			}
+			for _, dependent := range res.node.dependents {
+				dependent.waitingOn--
+				if dependent.waitingOn == 0 {
+					ready = append(ready, dependent)
+				}
+			}
+		case <-c.Done():
+			return ErrorBuildFailed{append(errs, c.Err())}
+		}
+	}
+
+	// Anything left over is waiting on something that's part of a
+	// cycle. Building it will lead to a suitable error:
+	if !failed {
+		for _, node := range nodes {
+			if node.built {
+				continue
+			}
+			if err := node.build(c); err != nil {
+				errs = append(errs, err)
+				break
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return ErrorBuildFailed{errs}
+	}
+	return nil
+}
+
+// buildNode is a single registration to be built by Build.
+type buildNode struct {
+	binding
+	// How many dependencies have yet to be built.
+	waitingOn int
+	// The nodes which depend on this one.
+	dependents []*buildNode
+	built      bool
+}
+
+type buildKey struct {
+	arg       *injectableValue
+	requester *Context
+}
+
+type buildResult struct {
+	node *buildNode
+	err  error
+}
+
+// buildNodes returns every registration that asking for something from the
+// Context would make use of, with the dependencies between them worked out.
+func (ctx *Context) buildNodes() []*buildNode {
+	nodes := []*buildNode{}
+	byKey := map[buildKey]*buildNode{}
+
+	// Dependencies that can't be found are left for build to complain about.
+	var add func(b binding) *buildNode
+	add = func(b binding) *buildNode {
+		// Only Scoped values differ depending on who asks for them:
+		key := buildKey{b.arg, nil}
+		if b.arg.lifetime == Scoped {
+			key.requester = b.requester
+		}
+		if node, ok := byKey[key]; ok {
+			return node
+		}
+		node := &buildNode{binding: b}
+		byKey[key] = node
+		for i, param := range b.arg.params {
+			deps, _ := b.paramBindings(i, param)
+			for _, dep := range deps {
+				if dep.lazy {
+					continue
+				}
+				depNode := add(dep)
+				depNode.dependents = append(depNode.dependents, node)
+				node.waitingOn++
+			}
+		}
+		nodes = append(nodes, node)
+		return node
+	}
+
+	// Only the registrations that would be found from this Context are
+	// built, along with whatever they depend on:
+	for _, c := range ctx.lineage() {
+		for _, e := range append(c.injectables.all(), c.decorators.all()...) {
+			if arg, ok := ctx.lookup(e.key); ok {
+				add(ctx.bindingOf(e.key, arg))
+			}
+		}
+		for _, e := range c.groups.all() {
+			add(ctx.bindingOf(e.key, e.val))
+		}
+	}
+
+	return nodes
+}
+
+// build creates the value for the node if need be. Any registered
+// function asking for a context.Context is handed c.
+func (node *buildNode) build(c context.Context) error {
+	if node.arg.itemMaker == nil || node.arg.lifetime == Transient {
+		return nil
+	}
+	_, err := newResolution(c).construct(node.requester, node.key, node.arg, node.requester.instanceOf(node.arg))
+	return err
+}
@@ -0,0 +1,83 @@
+package depends
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Decorate wraps whatever is registered against some type T, so that asking for
+// T from this Context (or any of its children) provides the value returned from
+// the decorator instead. The decorator is a function whose first argument is the
+// T to be wrapped, and which returns a T (optionally followed by a func() to clean
+// it up and then an error, as with Register). Any other arguments are injected.
+// For example, to add logging to some Store registered on a parent Context:
+//
+//	child.Decorate(func(s Store, log *Logger) Store {
+//		return &loggingStore{s, log}
+//	})
+//
+// The decorator is handed the value that asking for T would otherwise provide,
+// which may have been registered on this Context or any parent, and may itself
+// have been decorated. Decorators registered on the same Context apply in the
+// order that they were registered, and keep applying if T is registered again
+// (though, as with anything else, a value which has already been created is
+// kept).
+//
+// Something must already be registered against T, and the decorator takes on its
+// Lifetime: it is called once for each value that it wraps, and so once for this
+// Context if the value is a Singleton, once for each Context asking for it if the
+// value is Scoped, and every time it is asked for if the value is Transient.
+func (ctx *Context) Decorate(fn interface{}) {
+	ctx.decorate(fn, registerOptions{})
+}
+
+// decorate registers a decorator, with the module and call site
+// given in opts, if any.
+func (ctx *Context) decorate(fn interface{}, opts registerOptions) {
+	ty := reflect.TypeOf(fn)
+	if ty == nil || ty.Kind() != reflect.Func || ty.NumIn() == 0 || ty.IsVariadic() {
+		panic("Decorate expects a function whose first argument is the type to be decorated")
+	}
+
+	key := normalizeKey(ty.In(0))
+	inner, ok := ctx.lookup(key)
+	if !ok {
+		panic(fmt.Sprintf("Cannot decorate '%s' since it has not been registered", typeName(key.Ty)))
+	}
+
+	opts.asTy = ty.In(0)
+	opts.lifetime = inner.lifetime
+	opts.decorate = true
+	ctx.registerOne(fn, opts)
+}
+
+// decorated finds what the decorator given wraps when asked for using the
+// key given: the decorator registered before it on the same Context, or
+// failing that whatever the Context or its parents have registered.
+func (dec *injectableValue) decorated(key injectableKey) (*injectableValue, bool) {
+	c := dec.registeredOn
+	decs := c.decorators.get(key)
+	for i := len(decs) - 1; i > 0; i-- {
+		if decs[i] == dec {
+			return decs[i-1], true
+		}
+	}
+	if arg, ok := c.injectables.get(key); ok {
+		return arg, true
+	}
+	return c.parent.lookup(key)
+}
+
+// constructDecorated obtains the value that the decorator given wraps,
+// as the type that the decorator asks for.
+func (dec *injectableValue) constructDecorated(r *resolution, requester *Context, key injectableKey, ty reflect.Type) (reflect.Value, error) {
+	inner, ok := dec.decorated(key)
+	if !ok {
+		return reflect.Value{}, ErrorTypeNotRegistered{Ty: key.Ty, Name: key.Name}
+	}
+	item, err := r.construct(requester, key, inner, requester.instanceOf(inner))
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	return denormalizeValue(item, ty)
+}
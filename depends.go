@@ -29,31 +29,67 @@
 package depends
 
 import (
+	"context"
 	"fmt"
 	"reflect"
+	"sync"
+	"sync/atomic"
 )
 
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+var contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+var cleanupType = reflect.TypeOf((*func())(nil)).Elem()
+
+// registrations counts every registration made, so that
+// we can tell which order they were made in.
+var registrations uint64
+
 // Context is the owner of dependencies. A global context is available for convenience,
 // or one can create their own.
 type Context struct {
 	parent      *Context
 	injectables syncMap
+	groups      syncGroups
+	decorators  syncGroups
+	// instances of Scoped injectableValues for this Context.
+	scoped sync.Map
+	// mu guards the lifecycle state below.
+	mu       sync.Mutex
+	children []*Context
+	cleanups []cleanup
+	closed   bool
+	// True once the Context is in its parent's children.
+	// Guarded by the parent's mu rather than our own.
+	attached bool
+	// modules installed on this Context, by name.
+	modules map[string]*Module
+	// What to do about duplicate registrations.
+	duplicates  DuplicatePolicy
+	onDuplicate func(ErrorDuplicateRegistration)
 }
 
 // New creates a new Context
 func New() *Context {
 	return &Context{
-		parent:      nil,
-		injectables: syncMap{},
+		parent: nil,
 	}
 }
 
 // Child creates a child context. This Context can use anything registered
 // with it's parent, but the inverse is not true: anything registered on it
 // will not be visible to the parent context.
+//
+// Once a child has created something that needs cleaning up, its parent keeps
+// track of it so that it can Close the child when it is itself closed. Children
+// which are no longer needed should be closed so that they can be forgotten
+// about; children with nothing to clean up are not kept track of at all.
 func (ctx *Context) Child() *Context {
 	childCtx := New()
 	childCtx.parent = ctx
+	ctx.mu.Lock()
+	childCtx.duplicates = ctx.duplicates
+	childCtx.onDuplicate = ctx.onDuplicate
+	ctx.mu.Unlock()
 	return childCtx
 }
 
@@ -63,48 +99,220 @@ func (ctx *Context) Child() *Context {
 //
 // In the latter case, the function will be run the first time the type is
 // asked for. Anything the function asks for as an argument will be injected
-// into it, allowing for complex dependencies between registered types.
+// into it, allowing for complex dependencies between registered types. The
+// function may also return an error as its last value, in which case a non-nil
+// error is handed back from TryInject as an ErrorFactoryFailed.
+//
+// The function may return a func() cleanup function as its second value, which
+// will be called when the Context that owns the value created is closed. Values
+// created by functions which implement io.Closer are closed in the same way if
+// no cleanup function is returned. See Close for more.
 func (ctx *Context) Register(items ...interface{}) {
 	for _, item := range items {
-		ctx.registerOne(item)
+		ctx.registerOne(item, registerOptions{})
 	}
 }
 
-func (ctx *Context) registerOne(item interface{}) {
+// RegisterNamed registers dependencies into the Context in the same way as
+// Register, but against the name given. This allows several values of the same
+// type to be registered alongside each other, for instance a "primary" and a
+// "replica" database. Named values are not provided when asking for the type
+// alone; use Named (or GetNamed) to ask for them.
+func (ctx *Context) RegisterNamed(name string, items ...interface{}) {
+	for _, item := range items {
+		ctx.registerOne(item, registerOptions{name: name})
+	}
+}
+
+// RegisterGroup registers dependencies into the Context as contributions to a
+// group, rather than as the single value for their type. Any number of items of
+// the same type can be added to a group, and asking for a slice of that type
+// will provide all of them. Contributions from parent Contexts come first, and
+// then contributions from this Context, each in the order that they were
+// registered. For example, having registered some Handler values as a group,
+// we can ask for a []Handler (or []*Handler) to get them all.
+//
+// Asking for a slice of some type only provides the group if the slice type
+// itself has not been registered.
+func (ctx *Context) RegisterGroup(items ...interface{}) {
+	for _, item := range items {
+		ctx.registerOne(item, registerOptions{group: true})
+	}
+}
+
+// RegisterAs registers a dependency into the Context against an interface
+// type, rather than against the concrete type of the thing being registered.
+// The interface type is given by passing a nil pointer to it, for example
+// (*io.Reader)(nil).
+//
+// As with Register, we can register some value itself or a function that
+// returns some value. In either case, the value must implement the interface.
+// Asking for the interface type on Inject or TryInject will then provide it.
+func (ctx *Context) RegisterAs(item interface{}, iface interface{}) {
+	ctx.registerOne(item, registerOptions{asTy: interfaceType(iface)})
+}
+
+// RegisterWith registers a single dependency into the Context in the same
+// way as Register, but allows the registration to be tweaked using the
+// options provided. For example, to register a function that is called
+// every time the type it returns is asked for:
+//
+//	ctx.RegisterWith(newRequestID, WithLifetime(Transient))
+func (ctx *Context) RegisterWith(item interface{}, opts ...Option) {
+	ctx.registerOne(item, newRegisterOptions(opts))
+}
+
+func (ctx *Context) registerOne(item interface{}, opts registerOptions) {
+	if opts.callSite == "" {
+		opts.callSite = callSite()
+	}
+	asTy := opts.asTy
 	val := reflect.ValueOf(item)
 	ty := val.Type()
 	kind := ty.Kind()
 
 	if kind == reflect.Func {
 
-		if ty.NumOut() != 1 {
+		numOut := ty.NumOut()
+		returnsErr := numOut >= 2 && ty.Out(numOut-1) == errorType
+		returnsCleanup := numOut >= 2 && ty.Out(1) == cleanupType
+		validOut := numOut == 1 ||
+			(numOut == 2 && (returnsErr || returnsCleanup)) ||
+			(numOut == 3 && returnsErr && returnsCleanup)
+		if !validOut {
 			panic(fmt.Sprintf(
-				"If registering a function, it must return exactly one value"+
-					"of the type you'd like to be able to Inject, but the function"+
-					"provided returns %d items", ty.NumOut()))
+				"If registering a function, it must return exactly one value "+
+					"of the type you'd like to be able to Inject, optionally followed "+
+					"by a func() to clean it up and then an error, but the function "+
+					"provided returns %d items", numOut))
 		}
 
 		outTy := ty.Out(0)
-		ctx.injectables.put(normalizeKey(outTy), &injectableValue{
-			itemMaker: func(from []reflect.Type) (reflect.Value, error) {
-				vals, err := ctx.injectIntoFunction(from, nil, val)
+		if isOutStruct(outTy) && asTy != nil {
+			panic(fmt.Sprintf("The function being registered returns '%s', which provides several types and so cannot be registered as '%s'", typeName(outTy), typeName(asTy)))
+		}
+		if asTy != nil {
+			if !outTy.AssignableTo(asTy) {
+				panic(fmt.Sprintf("The function being registered returns '%s', which cannot be used as '%s'", typeName(outTy), typeName(asTy)))
+			}
+			outTy = asTy
+		}
+
+		key := normalizeKey(outTy)
+		key.Name = opts.name
+
+		// Things created from whichever Context asked for them can't
+		// be shared between Contexts, so singletons become scoped:
+		lifetime := opts.lifetime
+		if opts.fromRequester && lifetime == Singleton {
+			lifetime = Scoped
+		}
+
+		var value *injectableValue
+		value = &injectableValue{
+			retry:         opts.retry,
+			lifetime:      lifetime,
+			params:        funcParams(ty),
+			fromRequester: opts.fromRequester,
+			itemMaker: func(r *resolution, requester *Context) (reflect.Value, func() error, error) {
+				injectFrom := ctx
+				if opts.fromRequester {
+					injectFrom = requester
+				}
+				// Decorators are handed whatever they decorate first:
+				var supplied []reflect.Value
+				if opts.decorate {
+					innerVal, err := value.constructDecorated(r, requester, key, ty.In(0))
+					if err != nil {
+						return reflect.Value{}, nil, err
+					}
+					supplied = []reflect.Value{innerVal}
+				}
+
+				args, err := injectFrom.injectArgs(r, supplied, val)
+				if e, ok := err.(ErrorTypeNotRegistered); ok {
+					err = e.requiredBy(opts.callSite, opts.module, e.Pos)
+				}
+				if err != nil {
+					return reflect.Value{}, nil, err
+				}
+
+				// Failures from here on are down to the function itself:
+				vals, err := callFunction(val, args)
+				if e, ok := err.(ErrorPanicInFunction); ok {
+					e.RegisteredAt = opts.callSite
+					e.Module = opts.module
+					err = e
+				}
 				if err != nil {
-					return reflect.Value{}, err
+					return reflect.Value{}, nil, factoryError{err}
+				}
+				if returnsErr && !vals[numOut-1].IsNil() {
+					return reflect.Value{}, nil, factoryError{ErrorFactoryFailed{
+						Ty:     key.Ty,
+						Module: opts.module,
+						Chain:  r.types(),
+						Err:    vals[numOut-1].Interface().(error),
+					}}
+				}
+
+				item := normalizeValue(convertValue(vals[0], outTy))
+				if returnsCleanup {
+					return item, cleanupFunc(vals[1]), nil
 				}
-				return normalizeValue(vals[0]), nil
+				if isOutStruct(outTy) {
+					return item, outCloserFunc(item), nil
+				}
+				return item, closerFunc(item), nil
 			},
-		})
+		}
+
+		// A struct embedding Out provides each of its fields instead:
+		if isOutStruct(outTy) {
+			ctx.registerOut(key, value, opts)
+		} else {
+			ctx.put(key, opts, value)
+		}
 
 	} else {
 
-		ctx.injectables.put(normalizeKey(ty), &injectableValue{
-			item: normalizeValue(val),
+		if asTy != nil {
+			if !ty.AssignableTo(asTy) {
+				panic(fmt.Sprintf("The item being registered has type '%s', which cannot be used as '%s'", typeName(ty), typeName(asTy)))
+			}
+			val = convertValue(val, asTy)
+			ty = asTy
+		}
+
+		key := normalizeKey(ty)
+		key.Name = opts.name
+
+		ctx.put(key, opts, &injectableValue{
+			instance: instance{
+				item:  normalizeValue(val),
+				state: stateDone,
+			},
 		})
 
 	}
 
 }
 
+func (ctx *Context) put(key injectableKey, opts registerOptions, val *injectableValue) {
+	val.registeredOn = ctx
+	val.module = opts.module
+	val.callSite = opts.callSite
+	val.seq = atomic.AddUint64(&registrations, 1)
+	if opts.decorate {
+		val.decorator = true
+		ctx.decorators.add(key, val)
+	} else if opts.group {
+		ctx.groups.add(key, val)
+	} else {
+		ctx.putSingle(key, opts, val)
+	}
+}
+
 // Inject injects the dependencies asked for into the function provided. If anything
 // goes wrong, it will panic. It's expected that this will be used in favour of TryInject
 // in most cases, since failure to inject something is normally a sign of programmer error.
@@ -118,51 +326,134 @@ func (ctx *Context) Inject(fn interface{}) {
 
 // TryInject injects the dependencies asked for into the function provided. If anything
 // goes wrong, the function provided is not called and instead an error is returned
-// describing the issue.
+// describing the issue. If the function's last return value is an error, it is handed
+// back from TryInject, so that functions like func(db *DB) error can be used as entry
+// points.
 func (ctx *Context) TryInject(fn interface{}) error {
-	fnVal := reflect.ValueOf(fn)
-	_, err := ctx.injectIntoFunction(nil, nil, fnVal)
+	_, err := ctx.TryCall(fn)
+	return err
+}
+
+// TryInjectContext injects the dependencies asked for into the function provided in
+// the same way as TryInject, but allows the process to be cancelled using c. If c is
+// done before everything needed has been obtained, the function is not called and an
+// ErrorCanceled is returned. Values that were still being created at the time are
+// thrown away rather than being kept for next time.
+//
+// Any registered function (or the function provided) that asks for a context.Context
+// is handed c, so that slow work (dialing a database, for instance) can be abandoned
+// too. When using TryInject, context.Background() is handed over instead.
+func (ctx *Context) TryInjectContext(c context.Context, fn interface{}) error {
+	_, err := ctx.TryCallContext(c, fn)
 	return err
 }
 
-func (ctx *Context) injectIntoFunction(from []reflect.Type, fnRecv *reflect.Value, fnVal reflect.Value) (out []reflect.Value, outErr error) {
+// Call injects the dependencies asked for into the function provided in the same way
+// as Inject, and returns the values that it returns. If the function's last return
+// value is an error, it is left out of the values returned, and Call panics if it is
+// not nil.
+func (ctx *Context) Call(fn interface{}) []interface{} {
+	results, err := ctx.TryCall(fn)
+
+	if err != nil {
+		panic(err.Error())
+	}
+	return results
+}
+
+// TryCall injects the dependencies asked for into the function provided in the same
+// way as TryInject, and returns the values that it returns. If the function's last
+// return value is an error, it is left out of the values returned and handed back
+// as the error instead.
+func (ctx *Context) TryCall(fn interface{}) ([]interface{}, error) {
+	return ctx.TryCallContext(context.Background(), fn)
+}
+
+// TryCallContext is like TryCall, but allows the process to be cancelled using c in
+// the same way as TryInjectContext.
+func (ctx *Context) TryCallContext(c context.Context, fn interface{}) ([]interface{}, error) {
+	fnVal := reflect.ValueOf(fn)
+	out, err := ctx.injectIntoFunction(newResolution(c), nil, fnVal)
+	if err != nil {
+		return nil, err
+	}
+	return splitResults(fnVal.Type(), out)
+}
+
+// splitResults converts the values returned from a function of the type given,
+// separating out the trailing error if the function returns one.
+func splitResults(fnTy reflect.Type, out []reflect.Value) ([]interface{}, error) {
+	var err error
+	if n := fnTy.NumOut(); n > 0 && fnTy.Out(n-1) == errorType {
+		if e := out[n-1].Interface(); e != nil {
+			err = e.(error)
+		}
+		out = out[:n-1]
+	}
+
+	results := make([]interface{}, len(out))
+	for i, val := range out {
+		results[i] = val.Interface()
+	}
+	return results, err
+}
+
+func (ctx *Context) injectIntoFunction(r *resolution, supplied []reflect.Value, fnVal reflect.Value) ([]reflect.Value, error) {
+	args, err := ctx.injectArgs(r, supplied, fnVal)
+	if err != nil {
+		return []reflect.Value{}, err
+	}
+	return callFunction(fnVal, args)
+}
+
+// injectArgs obtains the arguments to call the function provided with. Any valid
+// values in supplied are used for the arguments at the same positions rather than
+// injecting them.
+func (ctx *Context) injectArgs(r *resolution, supplied []reflect.Value, fnVal reflect.Value) ([]reflect.Value, error) {
 	fnTy := fnVal.Type()
 	if fnTy.Kind() != reflect.Func {
-		return []reflect.Value{}, ErrorFunctionNotProvided{}
+		return nil, ErrorFunctionNotProvided{}
 	}
 
 	argCount := fnTy.NumIn()
 	args := []reflect.Value{}
 
-	// if a receiver is given, append that as arg one:
-	if fnRecv != nil {
-		args = append(args, *fnRecv)
-	}
-
-	// start after the receiver type if one given, else look at
-	// type of all function args and inject them:
-	for i := len(args); i < argCount; i++ {
+	// look at type of all function args and inject
+	// any that haven't been supplied:
+	for i := 0; i < argCount; i++ {
+		if i < len(supplied) && supplied[i].IsValid() {
+			args = append(args, supplied[i])
+			continue
+		}
 		argTy := fnTy.In(i)
-		argVal, err := ctx.getInjectable(from, argTy)
+		argVal, err := ctx.getInjectable(r, argTy)
 		if err != nil {
 			switch e := err.(type) {
 			// We need to add extra info to this error:
 			case ErrorTypeNotRegistered:
 				e.Pos = i + 1
-				outErr = e
-				return
+				return nil, e
 			default:
-				outErr = e
-				return
+				return nil, e
 			}
 		}
 		args = append(args, argVal)
 	}
 
-	// recover from any panic that occurs when calling the function:
+	// don't call the function if we've been cancelled in the meantime:
+	if err := r.canceled(); err != nil {
+		return nil, err
+	}
+
+	return args, nil
+}
+
+// callFunction calls the function provided with the arguments given,
+// recovering from any panic that occurs.
+func callFunction(fnVal reflect.Value, args []reflect.Value) (out []reflect.Value, outErr error) {
 	defer func() {
 		if e := recover(); e != nil {
-			outErr = ErrorPanicInFunction{e}
+			outErr = ErrorPanicInFunction{Panic: e}
 		}
 	}()
 
@@ -170,44 +461,159 @@ func (ctx *Context) injectIntoFunction(from []reflect.Type, fnRecv *reflect.Valu
 	return
 }
 
-func (ctx *Context) getInjectable(from []reflect.Type, ty reflect.Type) (reflect.Value, error) {
+func (ctx *Context) getInjectable(r *resolution, ty reflect.Type) (reflect.Value, error) {
+
+	// A context.Context is handed whatever the resolution was given:
+	if ty == contextType {
+		return reflect.ValueOf(&r.goCtx).Elem(), nil
+	}
+
+	// Provider[T] and func() (T, error) provide T later on, unless
+	// the function type itself has been registered:
+	if innerTy, ok := lazyOf(ty); ok {
+		if _, found := ctx.lookup(normalizeKey(ty)); !found {
+			return ctx.getLazy(r, ty, innerTy)
+		}
+	}
+
+	// Optional[T] asks for T if it has been registered:
+	if isOptionalType(ty) {
+		return ctx.getOptional(r, ty)
+	}
+
+	// A struct embedding In has each of its fields injected:
+	if isInStruct(ty) {
+		return ctx.getInStruct(r, ty)
+	}
+
+	// Named[T, N] asks for the T registered against the name N:
+	if isNamedType(ty) {
+		innerTy, name, err := namedKeyOf(ty)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		val, err := ctx.getNamedInjectable(r, innerTy, name)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		n := reflect.New(ty).Elem()
+		n.Field(0).Set(val)
+		return n, nil
+	}
+
+	return ctx.getNamedInjectable(r, ty, "")
+}
+
+func (ctx *Context) getNamedInjectable(r *resolution, ty reflect.Type, name string) (reflect.Value, error) {
 	normalKey := normalizeKey(ty)
-	arg, ok := ctx.injectables.get(normalKey)
-	normalTy := normalKey.Ty
+	normalKey.Name = name
+
+	if ctx.isClosed() {
+		return reflect.Value{}, ErrorContextClosed{}
+	}
 
-	// Delegate to a parent Context if one exists, else error:
+	// Look in this Context and then each parent in turn for the value.
+	// If it's not there, we may be able to provide a group instead:
+	arg, ok := ctx.lookup(normalKey)
 	if !ok {
-		if ctx.parent != nil {
-			return ctx.parent.getInjectable(from, ty)
+		if normalKey.Ty.Kind() == reflect.Slice {
+			return ctx.getGroup(r, ty, name)
 		}
-		return reflect.Value{}, ErrorTypeNotRegistered{Ty: normalTy}
+		return reflect.Value{}, ErrorTypeNotRegistered{Ty: normalKey.Ty, Name: name}
 	}
 
-	// run the instantiation method for an injected thing exactly once if one is present.
-	// the Injectable interface needs to be given a pointer receiver in order to match this.
-	var initErr error
-	arg.init.Do(func() {
+	// Obtain the item, running the itemMaker to construct it if this is
+	// the first time it's been asked for.
+	item, err := r.construct(ctx, normalKey, arg, ctx.instanceOf(arg))
+	if err != nil {
+		return reflect.Value{}, err
+	}
 
-		// if we have an itemMaker we need to run it to get our item, otherwise bail.
-		if arg.itemMaker == nil {
-			return
-		}
+	return denormalizeValue(item, ty)
+}
 
-		// if the type we key on has already been seen, complain as we've hit a loop:
-		if typeExistsInSlice(from, normalTy) {
-			initErr = ErrorCircularInject{appendType(from, normalTy)}
-			return
+// getGroup provides every contribution to the group of the slice element type
+// given, from this Context and its parents, as a slice of the type asked for.
+func (ctx *Context) getGroup(r *resolution, ty reflect.Type, name string) (reflect.Value, error) {
+	sliceTy := normalizeKey(ty).Ty
+	elemTy := sliceTy.Elem()
+	elemKey := normalizeKey(elemTy)
+	elemKey.Name = name
+
+	args := ctx.groupMembers(elemKey)
+	if len(args) == 0 {
+		return reflect.Value{}, ErrorTypeNotRegistered{Ty: sliceTy, Name: name}
+	}
+
+	out := reflect.MakeSlice(sliceTy, 0, len(args))
+	for _, arg := range args {
+		item, err := r.construct(ctx, elemKey, arg, ctx.instanceOf(arg))
+		if err != nil {
+			return reflect.Value{}, err
 		}
+		elem, err := denormalizeValue(item, elemTy)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		out = reflect.Append(out, elem)
+	}
+
+	return denormalizeValue(normalizeValue(out), ty)
+}
+
+// instanceOf returns the instance of the injectableValue given that should be
+// used when it's asked for from this Context, according to its lifetime.
+func (ctx *Context) instanceOf(arg *injectableValue) *instance {
+	switch arg.lifetime {
+	case Transient:
+		return &instance{}
+	case Scoped:
+		inst, _ := ctx.scoped.LoadOrStore(arg, &instance{})
+		return inst.(*instance)
+	default:
+		return &arg.instance
+	}
+}
 
-		// run the item maker to create our item, passing our chain of seen types.
-		res, err := arg.itemMaker(from)
-		initErr = err
-		arg.item = res
+// groupMembers returns every contribution to the group with the key given,
+// from the root Context down to this one.
+func (ctx *Context) groupMembers(key injectableKey) []*injectableValue {
+	var args []*injectableValue
+	for _, c := range ctx.lineage() {
+		args = append(args, c.groups.get(key)...)
+	}
+	return args
+}
 
-	})
-	if initErr != nil {
-		return reflect.Value{}, initErr
+// lineage returns this Context and all of its parents, root first.
+func (ctx *Context) lineage() []*Context {
+	var contexts []*Context
+	for c := ctx; c != nil; c = c.parent {
+		contexts = append([]*Context{c}, contexts...)
 	}
+	return contexts
+}
+
+// all returns every registration made on this Context, in the
+// order that they were made.
+func (ctx *Context) all() []entry {
+	out := append(ctx.injectables.all(), ctx.groups.all()...)
+	out = append(out, ctx.decorators.all()...)
+	sortEntries(out)
+	return out
+}
 
-	return denormalizeValue(arg.item, ty)
+// lookup finds the value registered against the key given in this
+// Context, or failing that the closest parent Context it exists in.
+// If that Context has decorators for the key, the last one is found.
+func (ctx *Context) lookup(key injectableKey) (*injectableValue, bool) {
+	for c := ctx; c != nil; c = c.parent {
+		if decs := c.decorators.get(key); len(decs) > 0 {
+			return decs[len(decs)-1], true
+		}
+		if arg, ok := c.injectables.get(key); ok {
+			return arg, true
+		}
+	}
+	return nil, false
 }
@@ -1,8 +1,15 @@
 package depends
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 )
 
 // Context should sort itself out if not called with New,
@@ -94,9 +101,9 @@ func TestFailedInjection(t *testing.T) {
 
 }
 
-// We can inject interfaces as well, but we have to wrap them in
-// concrete types owing to how reflection works. Using interfaces
-// in this way allows us to replace things with mocks to test.
+// We can inject interfaces as well. One way is to wrap them in
+// concrete types. Using interfaces in this way allows us to
+// replace things with mocks to test.
 type Thinger interface {
 	GetThings() int
 }
@@ -116,10 +123,10 @@ func TestInterfaceInjection(t *testing.T) {
 	ctx := New()
 
 	// interfaces themselves can't be passed straight
-	// into the registration unfortunately, because the
-	// interface details are not preserved. Thus, if we want
-	// to inject an interface, we need to wrap it into a
-	// concrete type like so:
+	// into Register, because the interface details are
+	// not preserved. One option is to wrap the interface
+	// into a concrete type like so (see RegisterAs for
+	// the other):
 	ctx.Register(ThingerContainer{Thing(100)})
 
 	err := ctx.TryInject(func(thinger ThingerContainer) {
@@ -134,6 +141,68 @@ func TestInterfaceInjection(t *testing.T) {
 
 }
 
+// Alternately, we can register things against an interface type
+// directly, and then inject the interface itself.
+func TestRegisterAsInterface(t *testing.T) {
+
+	ctx := New()
+	ctx.RegisterAs(Thing(100), (*Thinger)(nil))
+
+	err := ctx.TryInject(func(thinger Thinger, thingerPtr *Thinger) {
+		if thinger.GetThings() != 100 {
+			t.Error("argument is not what we expected (1)")
+		}
+		if (*thingerPtr).GetThings() != 100 {
+			t.Error("argument is not what we expected (2)")
+		}
+	})
+	if err != nil {
+		t.Errorf("Injecting 'Thinger' failed but should have been successful: %s", err)
+	}
+
+	// The concrete type is not registered; only the interface:
+	err = ctx.TryInject(func(thing Thing) {})
+	if _, ok := err.(ErrorTypeNotRegistered); !ok {
+		t.Errorf("Injecting 'Thing' should have failed but did not: %v", err)
+	}
+
+}
+
+// Functions returning concrete types can be registered against
+// an interface too, and functions returning the interface itself
+// are registered against it automatically.
+func TestRegisterAsInterfaceFactory(t *testing.T) {
+
+	type Other interface {
+		GetThings() int
+	}
+
+	ctx := New()
+	ctx.RegisterAs(func() Thing { return Thing(200) }, (*Thinger)(nil))
+	ctx.Register(func(th Thinger) Other { return th })
+
+	err := ctx.TryInject(func(thinger Thinger, other Other) {
+		if thinger.GetThings() != 200 || other.GetThings() != 200 {
+			t.Error("arguments are not what we expected")
+		}
+	})
+	if err != nil {
+		t.Errorf("Injecting interfaces failed but should have been successful: %s", err)
+	}
+
+}
+
+// Registering something that does not implement the interface panics:
+func TestRegisterAsNotImplemented(t *testing.T) {
+
+	ctx := New()
+
+	assertPanics(t, "value", func() { ctx.RegisterAs(12, (*Thinger)(nil)) })
+	assertPanics(t, "function", func() { ctx.RegisterAs(func() int { return 12 }, (*Thinger)(nil)) })
+	assertPanics(t, "not interface", func() { ctx.RegisterAs(Thing(1), Thing(1)) })
+
+}
+
 // We can ask for pointers or not-pointers to things; both should
 // work and return the same injected value:
 func TestPointersAndNonPointers(t *testing.T) {
@@ -306,6 +375,71 @@ func TestRegisterFactoryCalledOnce(t *testing.T) {
 
 }
 
+// Registered functions can also return an error alongside the
+// value, which is handed back from TryInject if it's not nil.
+func TestRegisterFactoriesWithErrors(t *testing.T) {
+
+	type Pool struct{}
+	type Client struct{}
+	type Fine int
+
+	errDial := errors.New("dial failed")
+
+	ctx := New()
+	ctx.Register(func() (Fine, error) {
+		return Fine(10), nil
+	})
+	ctx.Register(func() (*Pool, error) {
+		return nil, errDial
+	})
+	ctx.Register(func(p *Pool) Client {
+		return Client{}
+	})
+
+	err := ctx.TryInject(func(f Fine) {
+		if f != Fine(10) {
+			t.Error("argument is not what we expected")
+		}
+	})
+	if err != nil {
+		t.Errorf("Injecting 'Fine' failed but should have been successful: %s", err)
+	}
+
+	called := false
+	err = ctx.TryInject(func(c Client) {
+		called = true
+	})
+	if called {
+		t.Error("function should not have been called")
+	}
+	factoryErr, ok := err.(ErrorFactoryFailed)
+	if !ok {
+		t.Fatalf("expected ErrorFactoryFailed but got %v", err)
+	}
+	if !errors.Is(err, errDial) {
+		t.Error("error should wrap the error returned from the factory")
+	}
+	if factoryErr.Ty != reflect.TypeOf(Pool{}) {
+		t.Errorf("wrong type on error: %s", factoryErr.Ty)
+	}
+	expectedChain := []reflect.Type{reflect.TypeOf(Client{}), reflect.TypeOf(Pool{})}
+	if !reflect.DeepEqual(factoryErr.Chain, expectedChain) {
+		t.Errorf("wrong chain on error: %v", factoryErr.Chain)
+	}
+
+}
+
+// Registering functions which return the wrong things panics:
+func TestRegisterFactoriesBadReturns(t *testing.T) {
+
+	ctx := New()
+
+	assertPanics(t, "no returns", func() { ctx.Register(func() {}) })
+	assertPanics(t, "second not error", func() { ctx.Register(func() (int, int) { return 1, 2 }) })
+	assertPanics(t, "too many", func() { ctx.Register(func() (int, int, error) { return 1, 2, nil }) })
+
+}
+
 // The child context can see anything a parent can, but not the
 // other way around
 func TestChildContext(t *testing.T) {
@@ -332,3 +466,2390 @@ func TestChildContext(t *testing.T) {
 		}
 	})
 }
+
+// Values can be pulled out of a Context by type using Get and
+// MustGet, and registered against a specific type using Provide.
+func TestGenericAccessors(t *testing.T) {
+
+	type Foo int
+	type Unknown int
+
+	ctx := New()
+	ctx.Register(Foo(100))
+	Provide[Thinger](ctx, func(f Foo) Thing {
+		return Thing(int(f) * 2)
+	})
+
+	foo, err := Get[Foo](ctx)
+	if err != nil || foo != Foo(100) {
+		t.Errorf("Get[Foo] returned %v, %v", foo, err)
+	}
+
+	fooPtr := MustGet[*Foo](ctx)
+	*fooPtr = Foo(150)
+	if MustGet[Foo](ctx) != Foo(150) {
+		t.Error("MustGet[*Foo] should allow modification of the stored value")
+	}
+
+	thinger, err := Get[Thinger](ctx)
+	if err != nil || thinger.GetThings() != 300 {
+		t.Errorf("Get[Thinger] returned %v, %v", thinger, err)
+	}
+
+	_, err = Get[Unknown](ctx)
+	if e, ok := err.(ErrorTypeNotRegistered); !ok || e.Pos != 0 {
+		t.Errorf("Get[Unknown] should have failed but returned %v", err)
+	}
+
+	assertPanics(t, "MustGet unknown", func() { MustGet[Unknown](ctx) })
+	assertPanics(t, "Provide wrong type", func() { Provide[Thinger](ctx, 10) })
+
+}
+
+// Registered functions which (perhaps indirectly) depend on
+// themselves lead to an error rather than a deadlock.
+type CycleA struct{}
+type CycleB struct{}
+type CycleC struct{}
+
+func TestCircularInjection(t *testing.T) {
+
+	ctx := New()
+	ctx.Register(func(b CycleB) CycleA { return CycleA{} })
+	ctx.Register(func(a CycleA) CycleB { return CycleB{} })
+	ctx.Register(func(a CycleA) CycleC { return CycleC{} })
+
+	a := reflect.TypeOf(CycleA{})
+	b := reflect.TypeOf(CycleB{})
+	c := reflect.TypeOf(CycleC{})
+
+	var err error
+	withTimeout(t, func() {
+		err = ctx.TryInject(func(c CycleC) {})
+	})
+	cycleErr, ok := err.(ErrorCircularInject)
+	if !ok {
+		t.Fatalf("expected ErrorCircularInject but got %v", err)
+	}
+	if !reflect.DeepEqual(cycleErr.Chain, []reflect.Type{c, a, b, a}) {
+		t.Errorf("wrong chain on error: %s", cycleErr)
+	}
+
+	// Asking again should lead to the same error:
+	withTimeout(t, func() {
+		err = ctx.TryInject(func(a CycleA) {})
+	})
+	cycleErr, ok = err.(ErrorCircularInject)
+	if !ok || !reflect.DeepEqual(cycleErr.Chain, []reflect.Type{a, b, a}) {
+		t.Errorf("expected ErrorCircularInject but got %v", err)
+	}
+
+}
+
+// Cycles are spotted even when they span parent and child Contexts.
+func TestCircularInjectionAcrossContexts(t *testing.T) {
+
+	ctx := New()
+	ctx.Register(func(b CycleB) CycleA { return CycleA{} })
+	ctx.Register(func(a CycleA) CycleB { return CycleB{} })
+
+	childCtx := ctx.Child()
+	childCtx.Register(func(a CycleA) CycleC { return CycleC{} })
+
+	var err error
+	withTimeout(t, func() {
+		err = childCtx.TryInject(func(c CycleC) {})
+	})
+	cycleErr, ok := err.(ErrorCircularInject)
+	expected := []reflect.Type{reflect.TypeOf(CycleC{}), reflect.TypeOf(CycleA{}), reflect.TypeOf(CycleB{}), reflect.TypeOf(CycleA{})}
+	if !ok || !reflect.DeepEqual(cycleErr.Chain, expected) {
+		t.Errorf("expected ErrorCircularInject but got %v", err)
+	}
+
+}
+
+// If two goroutines each start constructing one half of a cycle,
+// neither should wait forever on the other.
+func TestCircularInjectionConcurrent(t *testing.T) {
+
+	type GateA struct{}
+	type GateB struct{}
+
+	for run := 0; run < 20; run++ {
+
+		// Both goroutines wait here until they have each begun
+		// constructing their half of the cycle:
+		barrier := sync.WaitGroup{}
+		barrier.Add(2)
+
+		ctx := New()
+		ctx.Register(func() GateA { barrier.Done(); barrier.Wait(); return GateA{} })
+		ctx.Register(func() GateB { barrier.Done(); barrier.Wait(); return GateB{} })
+		ctx.Register(func(g GateA, b CycleB) CycleA { return CycleA{} })
+		ctx.Register(func(g GateB, a CycleA) CycleB { return CycleB{} })
+
+		errs := make(chan error, 2)
+		withTimeout(t, func() {
+			go func() { errs <- ctx.TryInject(func(a CycleA) {}) }()
+			go func() { errs <- ctx.TryInject(func(b CycleB) {}) }()
+			for i := 0; i < 2; i++ {
+				err := <-errs
+				cycleErr, ok := err.(ErrorCircularInject)
+				if !ok {
+					t.Errorf("expected ErrorCircularInject but got %v", err)
+					continue
+				}
+				chain := cycleErr.Chain
+				if len(chain) != 3 || chain[0] != chain[2] {
+					t.Errorf("unexpected chain on error: %s", cycleErr)
+				}
+			}
+		})
+
+	}
+
+}
+
+// Lots of concurrent injections of values which depend on one
+// another should all succeed, and construct each value once.
+func TestConcurrentInjection(t *testing.T) {
+
+	type Foo int
+	type Bar int
+	type Wibble int
+
+	var fooCalls, barCalls, wibbleCalls int
+	mu := sync.Mutex{}
+	count := func(n *int) {
+		mu.Lock()
+		*n++
+		mu.Unlock()
+	}
+
+	ctx := New()
+	ctx.Register(func(b Bar, w Wibble) Foo { count(&fooCalls); return Foo(int(b) + int(w)) })
+	ctx.Register(func(w Wibble) Bar { count(&barCalls); return Bar(w) })
+	ctx.Register(func() Wibble { count(&wibbleCalls); time.Sleep(time.Millisecond); return Wibble(1) })
+
+	withTimeout(t, func() {
+		wg := sync.WaitGroup{}
+		for i := 0; i < 50; i++ {
+			wg.Add(3)
+			go func() { defer wg.Done(); ctx.Inject(func(f Foo) {}) }()
+			go func() { defer wg.Done(); ctx.Inject(func(b Bar, f Foo) {}) }()
+			go func() { defer wg.Done(); ctx.Inject(func(w Wibble) {}) }()
+		}
+		wg.Wait()
+	})
+
+	if fooCalls != 1 || barCalls != 1 || wibbleCalls != 1 {
+		t.Errorf("registered functions called more than once: %d, %d, %d", fooCalls, barCalls, wibbleCalls)
+	}
+
+}
+
+// We can register several values of the same type against
+// different names, and ask for each of them by name.
+type DB struct{ Host string }
+type Primary struct{}
+type Replica struct{}
+type Missing struct{}
+
+func (Primary) DependsName() string { return "primary" }
+func (Replica) DependsName() string { return "replica" }
+func (Missing) DependsName() string { return "missing" }
+
+func TestNamedInjection(t *testing.T) {
+
+	type Service struct{ Read, Write string }
+
+	ctx := New()
+	ctx.Register(&DB{"default"})
+	ctx.RegisterNamed("primary", &DB{"primary-host"})
+	ctx.RegisterNamed("replica", func() *DB { return &DB{"replica-host"} })
+	ctx.Register(func(p Named[*DB, Primary], r Named[DB, Replica]) Service {
+		return Service{Write: p.Value.Host, Read: r.Value.Host}
+	})
+
+	err := ctx.TryInject(func(db *DB, p Named[*DB, Primary], r Named[*DB, Replica], s Service) {
+		if db.Host != "default" || p.Value.Host != "primary-host" || r.Value.Host != "replica-host" {
+			t.Error("arguments are not what we expected")
+		}
+		if s.Write != "primary-host" || s.Read != "replica-host" {
+			t.Error("named values not injected into registered function")
+		}
+	})
+	if err != nil {
+		t.Errorf("Injecting named values failed but should have been successful: %s", err)
+	}
+
+	replica, err := GetNamed[*DB](ctx, "replica")
+	if err != nil || replica.Host != "replica-host" {
+		t.Errorf("GetNamed returned %v, %v", replica, err)
+	}
+
+	primary := MustGet[Named[DB, Primary]](ctx)
+	if primary.Value.Host != "primary-host" {
+		t.Errorf("MustGet returned %v", primary)
+	}
+
+	// Names we haven't registered lead to an error mentioning them:
+	err = ctx.TryInject(func(db *DB, m Named[*DB, Missing]) {})
+	notRegistered, ok := err.(ErrorTypeNotRegistered)
+	if !ok || notRegistered.Name != "missing" || notRegistered.Pos != 2 {
+		t.Errorf("expected ErrorTypeNotRegistered but got %v", err)
+	}
+
+	// Child Contexts can override named values:
+	childCtx := ctx.Child()
+	childCtx.RegisterNamed("replica", &DB{"child-replica-host"})
+	childCtx.Inject(func(p Named[DB, Primary], r Named[DB, Replica]) {
+		if p.Value.Host != "primary-host" || r.Value.Host != "child-replica-host" {
+			t.Error("child context named values are not what we expected")
+		}
+	})
+
+	// Pointer name types are nil, so can't be asked for their name:
+	if _, ok := ctx.TryInject(func(Named[*DB, *Primary]) {}).(ErrorInvalidName); !ok {
+		t.Error("expected ErrorInvalidName from TryInject")
+	}
+	if _, ok := ctx.CanInject(func(Named[*DB, *Primary]) {}).(ErrorInvalidName); !ok {
+		t.Error("expected ErrorInvalidName from CanInject")
+	}
+	ctx.Register(func(Named[*DB, *Primary]) float64 { return 0 })
+	ctx.Graph()
+
+	// Structs embedding a Named type are just ordinary types:
+	type PrimaryDB struct{ Named[*DB, Primary] }
+	if _, ok := ctx.TryInject(func(PrimaryDB) {}).(ErrorTypeNotRegistered); !ok {
+		t.Error("expected ErrorTypeNotRegistered for a struct embedding Named")
+	}
+	ctx.Register(PrimaryDB{Named[*DB, Primary]{&DB{"embedded"}}})
+	ctx.Inject(func(p PrimaryDB) {
+		if p.Value.Host != "embedded" {
+			t.Errorf("expected the registered struct, got %v", p.Value)
+		}
+	})
+
+}
+
+// Things registered as part of a group accumulate rather than replacing
+// each other, and can all be injected at once by asking for a slice.
+func TestGroupInjection(t *testing.T) {
+
+	type Handler struct{ Path string }
+	type Prefix string
+
+	ctx := New()
+	ctx.Register(Prefix("/api"))
+	ctx.RegisterGroup(Handler{"/parent1"})
+	ctx.RegisterGroup(func(p Prefix) *Handler { return &Handler{string(p) + "/parent2"} })
+
+	childCtx := ctx.Child()
+	childCtx.RegisterGroup(Handler{"/child1"}, Handler{"/child2"})
+
+	paths := func(hs []Handler) []string {
+		out := []string{}
+		for _, h := range hs {
+			out = append(out, h.Path)
+		}
+		return out
+	}
+
+	ctx.Inject(func(hs []Handler) {
+		if !reflect.DeepEqual(paths(hs), []string{"/parent1", "/api/parent2"}) {
+			t.Errorf("unexpected parent group: %v", hs)
+		}
+	})
+
+	childCtx.Inject(func(hs []Handler, hps []*Handler) {
+		expected := []string{"/parent1", "/api/parent2", "/child1", "/child2"}
+		if !reflect.DeepEqual(paths(hs), expected) {
+			t.Errorf("unexpected child group: %v", hs)
+		}
+		if len(hps) != 4 || hps[1].Path != "/api/parent2" {
+			t.Errorf("unexpected child group of pointers: %v", hps)
+		}
+	})
+
+	// Groups are independent of a single value of the same type:
+	err := ctx.TryInject(func(h Handler) {})
+	if _, ok := err.(ErrorTypeNotRegistered); !ok {
+		t.Errorf("expected ErrorTypeNotRegistered but got %v", err)
+	}
+
+	// Registering the slice type itself takes precedence:
+	childCtx.Register([]Handler{{"/override"}})
+	childCtx.Inject(func(hs []Handler) {
+		if !reflect.DeepEqual(paths(hs), []string{"/override"}) {
+			t.Errorf("registered slice should take precedence: %v", hs)
+		}
+	})
+
+	// Asking for an empty group is an error:
+	err = ctx.TryInject(func(ps []Prefix) {})
+	if _, ok := err.(ErrorTypeNotRegistered); !ok {
+		t.Errorf("expected ErrorTypeNotRegistered but got %v", err)
+	}
+
+}
+
+// Groups of interfaces work as well, and named values are kept
+// separate from groups.
+func TestGroupInterfaces(t *testing.T) {
+
+	ctx := New()
+	ctx.RegisterGroup(func() Thinger { return Thing(1) })
+	ctx.RegisterGroup(func() Thinger { return Thing(2) })
+	ctx.RegisterNamed("primary", func() Thinger { return Thing(3) })
+
+	things, err := Get[[]Thinger](ctx)
+	if err != nil || len(things) != 2 || things[0].GetThings() != 1 || things[1].GetThings() != 2 {
+		t.Errorf("unexpected group of interfaces: %v, %v", things, err)
+	}
+
+	_, err = GetNamed[[]Thinger](ctx, "primary")
+	if e, ok := err.(ErrorTypeNotRegistered); !ok || e.Name != "primary" {
+		t.Errorf("expected ErrorTypeNotRegistered but got %v", err)
+	}
+
+}
+
+// Registered functions can be given a lifetime, which determines how
+// often they are called.
+func TestLifetimes(t *testing.T) {
+
+	type SingletonThing int
+	type TransientThing int
+	type ScopedThing int
+
+	var singletons, transients, scopeds int
+
+	ctx := New()
+	ctx.RegisterWith(func() SingletonThing { singletons++; return SingletonThing(singletons) }, WithLifetime(Singleton))
+	ctx.RegisterWith(func() TransientThing { transients++; return TransientThing(transients) }, WithLifetime(Transient))
+	ctx.RegisterWith(func() ScopedThing { scopeds++; return ScopedThing(scopeds) }, WithLifetime(Scoped))
+
+	childCtx1 := ctx.Child()
+	childCtx2 := ctx.Child()
+
+	get := func(c *Context) (SingletonThing, TransientThing, ScopedThing) {
+		var a SingletonThing
+		var b TransientThing
+		var c2 ScopedThing
+		c.Inject(func(s SingletonThing, t TransientThing, sc ScopedThing) {
+			a, b, c2 = s, t, sc
+		})
+		return a, b, c2
+	}
+
+	s1, t1, sc1 := get(ctx)
+	s2, t2, sc2 := get(ctx)
+	s3, t3, sc3 := get(childCtx1)
+	s4, t4, sc4 := get(childCtx1)
+	s5, t5, sc5 := get(childCtx2)
+
+	if s1 != 1 || s2 != 1 || s3 != 1 || s4 != 1 || s5 != 1 || singletons != 1 {
+		t.Errorf("singleton should be created once: %d %d %d %d %d", s1, s2, s3, s4, s5)
+	}
+	if t1 != 1 || t2 != 2 || t3 != 3 || t4 != 4 || t5 != 5 || transients != 5 {
+		t.Errorf("transient should be created every time: %d %d %d %d %d", t1, t2, t3, t4, t5)
+	}
+	if sc1 != 1 || sc2 != 1 || sc3 != 2 || sc4 != 2 || sc5 != 3 || scopeds != 3 {
+		t.Errorf("scoped should be created once per context: %d %d %d %d %d", sc1, sc2, sc3, sc4, sc5)
+	}
+
+}
+
+// Transient functions which depend on themselves are still caught.
+func TestTransientCircularInjection(t *testing.T) {
+
+	ctx := New()
+	ctx.RegisterWith(func(b CycleB) CycleA { return CycleA{} }, WithLifetime(Transient))
+	ctx.RegisterWith(func(a CycleA) CycleB { return CycleB{} }, WithLifetime(Scoped))
+
+	var err error
+	withTimeout(t, func() {
+		err = ctx.Child().TryInject(func(a CycleA) {})
+	})
+	cycleErr, ok := err.(ErrorCircularInject)
+	expected := []reflect.Type{reflect.TypeOf(CycleA{}), reflect.TypeOf(CycleB{}), reflect.TypeOf(CycleA{})}
+	if !ok || !reflect.DeepEqual(cycleErr.Chain, expected) {
+		t.Errorf("expected ErrorCircularInject but got %v", err)
+	}
+
+}
+
+// Options can be combined, for instance to create named groups.
+func TestRegisterWithOptions(t *testing.T) {
+
+	ctx := New()
+	ctx.RegisterWith(Thing(1), As((*Thinger)(nil)), WithName("things"), InGroup())
+	ctx.RegisterWith(func() Thing { return Thing(2) }, As((*Thinger)(nil)), WithName("things"), InGroup())
+	Provide[Thinger](ctx, Thing(3), WithName("things"), InGroup())
+
+	things, err := GetNamed[[]Thinger](ctx, "things")
+	if err != nil || len(things) != 3 || things[2].GetThings() != 3 {
+		t.Errorf("unexpected named group: %v, %v", things, err)
+	}
+
+	_, err = Get[[]Thinger](ctx)
+	if _, ok := err.(ErrorTypeNotRegistered); !ok {
+		t.Errorf("expected ErrorTypeNotRegistered but got %v", err)
+	}
+
+}
+
+// Registered functions can resolve their dependencies from the Context
+// that asks for their value, so that children can override them.
+func TestFromRequester(t *testing.T) {
+
+	type Foo int
+	type Derived int
+	type Captured int
+
+	calls := 0
+
+	ctx := New()
+	ctx.Register(Foo(1))
+	ctx.RegisterWith(func(f Foo) Derived { calls++; return Derived(f * 10) }, FromRequester())
+	ctx.Register(func(f Foo) Captured { return Captured(f * 10) })
+
+	overriding := ctx.Child()
+	overriding.Register(Foo(2))
+
+	grandchild := overriding.Child()
+	plain := ctx.Child()
+
+	check := func(name string, c *Context, expected Derived) {
+		c.Inject(func(d Derived, d2 Derived, cap Captured) {
+			if d != expected || d2 != expected {
+				t.Errorf("%s: expected %d but got %d", name, expected, d)
+			}
+			if cap != Captured(10) {
+				t.Errorf("%s: captured value should come from the parent", name)
+			}
+		})
+	}
+
+	check("parent", ctx, Derived(10))
+	check("overriding child", overriding, Derived(20))
+	check("grandchild", grandchild, Derived(20))
+	check("plain child", plain, Derived(10))
+
+	if calls != 4 {
+		t.Errorf("expected the function to be called once per context, not %d times", calls)
+	}
+
+}
+
+// Values created by registered functions are cleaned up in reverse
+// order when the Context that owns them is closed.
+type closeRecorder struct {
+	name   string
+	closed *[]string
+	err    error
+}
+
+func (c *closeRecorder) Close() error {
+	*c.closed = append(*c.closed, c.name)
+	return c.err
+}
+
+func TestClose(t *testing.T) {
+
+	type Pool struct{}
+	type Client struct{}
+	type Listener struct{ *closeRecorder }
+	type Registered struct{ *closeRecorder }
+	type Session struct{ *closeRecorder }
+
+	closed := []string{}
+	errClose := errors.New("close failed")
+
+	ctx := New()
+	ctx.Register(func() (Pool, func()) {
+		return Pool{}, func() { closed = append(closed, "pool") }
+	})
+	ctx.Register(func(p Pool) (Client, func(), error) {
+		return Client{}, func() { closed = append(closed, "client") }, nil
+	})
+	ctx.Register(func(c Client) *Listener {
+		return &Listener{&closeRecorder{"listener", &closed, errClose}}
+	})
+	ctx.Register(Registered{&closeRecorder{"registered", &closed, nil}})
+	ctx.RegisterWith(func() Session {
+		return Session{&closeRecorder{"session", &closed, nil}}
+	}, WithLifetime(Scoped))
+
+	childCtx := ctx.Child()
+	ctx.Inject(func(l Listener, r Registered) {})
+	childCtx.Inject(func(s Session, c Client) {})
+
+	err := ctx.Close()
+	expected := []string{"session", "listener", "client", "pool"}
+	if !reflect.DeepEqual(closed, expected) {
+		t.Errorf("closed in the wrong order: %v", closed)
+	}
+	closeErr, ok := err.(ErrorCloseFailed)
+	if !ok || len(closeErr.Errors) != 1 || !errors.Is(err, errClose) {
+		t.Errorf("expected ErrorCloseFailed but got %v", err)
+	}
+
+	// Closing again does nothing, and nothing more can be injected:
+	if err := ctx.Close(); err != nil {
+		t.Errorf("second close should not fail: %s", err)
+	}
+	if err := ctx.TryInject(func(p Pool) {}); err != (ErrorContextClosed{}) {
+		t.Errorf("expected ErrorContextClosed but got %v", err)
+	}
+	if err := childCtx.TryInject(func(p Pool) {}); err != (ErrorContextClosed{}) {
+		t.Errorf("expected ErrorContextClosed but got %v", err)
+	}
+
+}
+
+// Closing a child leaves the parent alone, and panics in cleanup
+// functions are caught.
+func TestCloseChild(t *testing.T) {
+
+	type Foo struct{}
+	type Bar struct{}
+
+	closed := []string{}
+
+	ctx := New()
+	ctx.Register(func() (Foo, func()) {
+		return Foo{}, func() { closed = append(closed, "foo") }
+	})
+	ctx.RegisterWith(func() (Bar, func()) {
+		return Bar{}, func() { panic("oops") }
+	}, WithLifetime(Scoped))
+
+	childCtx := ctx.Child()
+	childCtx.Inject(func(f Foo, b Bar) {})
+
+	err := childCtx.Close()
+	closeErr, ok := err.(ErrorCloseFailed)
+	if !ok || len(closeErr.Errors) != 1 {
+		t.Fatalf("expected ErrorCloseFailed but got %v", err)
+	}
+	var panicErr ErrorPanicInFunction
+	if !errors.As(closeErr.Errors[0], &panicErr) {
+		t.Errorf("expected a panic to be reported but got %v", closeErr.Errors[0])
+	}
+	if len(closed) != 0 {
+		t.Errorf("parent values should not be cleaned up by the child: %v", closed)
+	}
+
+	ctx.Inject(func(f Foo) {})
+	if err := ctx.Close(); err != nil {
+		t.Errorf("parent close should not fail: %s", err)
+	}
+	if !reflect.DeepEqual(closed, []string{"foo"}) {
+		t.Errorf("parent values should be cleaned up: %v", closed)
+	}
+
+}
+
+// Nothing can be created for a Context once it, or any parent, has been
+// closed, and Transient values are left to whatever asked for them.
+func TestCloseLineage(t *testing.T) {
+
+	type Conn struct{ *closeRecorder }
+	type Temp struct{ *closeRecorder }
+
+	closed := []string{}
+	calls := 0
+
+	ctx := New()
+	ctx.Register(func() Conn {
+		calls++
+		return Conn{&closeRecorder{"conn", &closed, nil}}
+	})
+	ctx.RegisterWith(func() Temp {
+		return Temp{&closeRecorder{"temp", &closed, nil}}
+	}, WithLifetime(Transient))
+
+	for i := 0; i < 100; i++ {
+		ctx.Inject(func(Temp) {})
+	}
+	if len(ctx.cleanups) != 0 {
+		t.Errorf("transient values should not be kept track of: %d", len(ctx.cleanups))
+	}
+
+	ctx.Close()
+	if err := ctx.Child().TryInject(func(Conn) {}); err != (ErrorContextClosed{}) {
+		t.Errorf("expected ErrorContextClosed but got %v", err)
+	}
+	if calls != 0 || len(closed) != 0 {
+		t.Errorf("nothing should have been created or closed: %d, %v", calls, closed)
+	}
+
+	// Anything that is created anyway is cleaned up straight away:
+	if err := ctx.Build(context.Background(), 1); !errors.Is(err, ErrorContextClosed{}) {
+		t.Errorf("expected ErrorContextClosed but got %v", err)
+	}
+	if !reflect.DeepEqual(closed, []string{"conn"}) {
+		t.Errorf("expected the value to be closed: %v", closed)
+	}
+
+}
+
+// Children are only kept track of by their parent once they have
+// something to clean up, so unclosed children aren't kept around.
+func TestChildRetention(t *testing.T) {
+
+	type Conn struct{ *closeRecorder }
+	type Plain int
+
+	closed := []string{}
+
+	ctx := New()
+	ctx.Register(Plain(1))
+	ctx.RegisterWith(func() Conn {
+		return Conn{&closeRecorder{"conn", &closed, nil}}
+	}, WithLifetime(Scoped))
+
+	for i := 0; i < 100; i++ {
+		child := ctx.Child()
+		child.Inject(func(Plain) {})
+	}
+	if len(ctx.children) != 0 {
+		t.Errorf("children with nothing to clean up should not be kept: %d", len(ctx.children))
+	}
+
+	// A grandchild with something to clean up is closed along
+	// with the root, even though its parent had nothing:
+	grandchild := ctx.Child().Child()
+	grandchild.Inject(func(Conn) {})
+	if len(ctx.children) != 1 {
+		t.Errorf("expected the grandchild's parent to be kept: %d", len(ctx.children))
+	}
+	if err := ctx.Close(); err != nil {
+		t.Errorf("close should not fail: %s", err)
+	}
+	if !reflect.DeepEqual(closed, []string{"conn"}) {
+		t.Errorf("expected the grandchild to be closed: %v", closed)
+	}
+
+}
+
+// The exported fields of structs can be filled in from a Context.
+func TestInjectStruct(t *testing.T) {
+
+	type Foo int
+	type Bar string
+	type Unknown int
+
+	type Handler struct {
+		Foo     Foo
+		BarPtr  *Bar
+		Primary *DB `depends:"name=primary"`
+		Thinger Thinger
+		Replica Named[*DB, ReplicaName]
+		Ctx     context.Context
+		Skipped Unknown `depends:"-"`
+		private Unknown
+	}
+
+	ctx := New()
+	ctx.Register(Foo(10), Bar("bar"))
+	ctx.RegisterNamed("primary", &DB{"primary-host"})
+	ctx.RegisterNamed("replica", &DB{"replica-host"})
+	ctx.RegisterAs(Thing(5), (*Thinger)(nil))
+
+	h := Handler{}
+	err := ctx.TryInjectStruct(&h)
+	if err != nil {
+		t.Fatalf("Injecting struct failed but should have been successful: %s", err)
+	}
+	if h.Foo != Foo(10) || *h.BarPtr != Bar("bar") || h.Primary.Host != "primary-host" || h.Thinger.GetThings() != 5 {
+		t.Errorf("struct fields are not what we expected: %+v", h)
+	}
+	if h.Replica.Value.Host != "replica-host" || h.Ctx == nil {
+		t.Errorf("fields should be injected as though they were arguments: %+v", h)
+	}
+	if h.Skipped != 0 || h.private != 0 {
+		t.Error("skipped fields should not be injected")
+	}
+
+}
+
+// Every field that cannot be injected is reported, and the struct
+// is left untouched.
+func TestInjectStructErrors(t *testing.T) {
+
+	type Foo int
+	type Unknown1 int
+	type Unknown2 int
+
+	type Handler struct {
+		Foo      Foo
+		First    Unknown1
+		Second   Unknown2
+		Replica  *DB      `depends:"name=replica"`
+		BadTag   Foo      `depends:"nonsense"`
+		Fine     Foo      `depends:""`
+		Ignoring Unknown1 `depends:"-"`
+	}
+
+	ctx := New()
+	ctx.Register(Foo(10))
+
+	h := Handler{}
+	err := ctx.TryInjectStruct(&h)
+	structErr, ok := err.(ErrorInjectStruct)
+	if !ok {
+		t.Fatalf("expected ErrorInjectStruct but got %v", err)
+	}
+	fields := []string{}
+	for _, f := range structErr.Fields {
+		fields = append(fields, f.Field)
+	}
+	if !reflect.DeepEqual(fields, []string{"First", "Second", "Replica", "BadTag"}) {
+		t.Errorf("unexpected fields reported: %v", fields)
+	}
+	var notRegistered ErrorTypeNotRegistered
+	if !errors.As(err, &notRegistered) || notRegistered.Ty != reflect.TypeOf(Unknown1(0)) {
+		t.Errorf("expected ErrorTypeNotRegistered to be wrapped but got %v", err)
+	}
+	if h.Foo != 0 {
+		t.Error("struct should not be modified if injection fails")
+	}
+
+	if err := ctx.TryInjectStruct(h); err != (ErrorStructNotProvided{}) {
+		t.Errorf("expected ErrorStructNotProvided but got %v", err)
+	}
+	assertPanics(t, "InjectStruct", func() { ctx.InjectStruct(&h) })
+
+}
+
+// Validate checks every registered function without calling any of them.
+func TestValidate(t *testing.T) {
+
+	type Foo int
+	type Bar int
+	type Wibble int
+
+	called := false
+
+	ctx := New()
+	ctx.Register(Foo(1))
+	ctx.RegisterWith(func(f Foo, b *Bar) Wibble { called = true; return Wibble(1) }, FromRequester())
+	ctx.RegisterWith(func(w Wibble, p Named[*DB, Primary]) Thinger { called = true; return Thing(1) }, InGroup(), FromRequester())
+
+	childCtx := ctx.Child()
+	childCtx.Register(Bar(2))
+	childCtx.RegisterNamed("primary", &DB{})
+
+	if err := childCtx.Validate(); err != nil {
+		t.Errorf("child context should be valid: %s", err)
+	}
+
+	// The parent is missing Bar and the named DB:
+	err := ctx.Validate()
+	validationErr, ok := err.(ErrorValidation)
+	if !ok || len(validationErr.Errors) != 2 {
+		t.Fatalf("expected ErrorValidation with 2 errors but got %v", err)
+	}
+	first, ok1 := validationErr.Errors[0].(ErrorUnsatisfiedDependency)
+	second, ok2 := validationErr.Errors[1].(ErrorUnsatisfiedDependency)
+	if !ok1 || !ok2 {
+		t.Fatalf("expected ErrorUnsatisfiedDependency errors but got %v", err)
+	}
+	firstErr, _ := first.Err.(ErrorTypeNotRegistered)
+	secondErr, _ := second.Err.(ErrorTypeNotRegistered)
+	if first.Ty != reflect.TypeOf(Wibble(0)) || firstErr.RequiredBy == "" || firstErr != (ErrorTypeNotRegistered{Ty: reflect.TypeOf(Bar(0)), RequiredBy: firstErr.RequiredBy, RequiredByPos: 2}) {
+		t.Errorf("unexpected first error: %s", first)
+	}
+	if second.Ty != reflect.TypeOf((*Thinger)(nil)).Elem() || secondErr.RequiredBy == "" || secondErr != (ErrorTypeNotRegistered{Ty: reflect.TypeOf(DB{}), Name: "primary", RequiredBy: secondErr.RequiredBy, RequiredByPos: 2}) {
+		t.Errorf("unexpected second error: %s", second)
+	}
+
+	// Functions which aren't FromRequester are resolved from the
+	// Context they're registered on, so the child can't help:
+	ctx.Register(func(b Bar) CycleC { called = true; return CycleC{} })
+	err = childCtx.Validate()
+	var unsatisfied ErrorUnsatisfiedDependency
+	if !errors.As(err, &unsatisfied) || unsatisfied.Ty != reflect.TypeOf(CycleC{}) {
+		t.Errorf("expected ErrorUnsatisfiedDependency but got %v", err)
+	}
+
+	if called {
+		t.Error("Validate should not call registered functions")
+	}
+
+}
+
+// Validate reports cycles and ambiguous registrations too.
+func TestValidateCyclesAndAmbiguity(t *testing.T) {
+
+	type Handler struct{}
+
+	ctx := New()
+	ctx.Register(func(b CycleB) CycleA { return CycleA{} })
+	ctx.Register(func(a CycleA) CycleB { return CycleB{} })
+	ctx.Register(func(a CycleA) CycleC { return CycleC{} })
+	ctx.RegisterGroup(Handler{})
+
+	childCtx := ctx.Child()
+	childCtx.Register([]Handler{})
+
+	if err := ctx.Validate(); err != nil {
+		cycles := 0
+		for _, e := range err.(ErrorValidation).Errors {
+			if _, ok := e.(ErrorCircularInject); ok {
+				cycles++
+			} else {
+				t.Errorf("unexpected error: %s", e)
+			}
+		}
+		if cycles != 1 {
+			t.Errorf("expected the cycle to be reported once but got %d", cycles)
+		}
+	} else {
+		t.Error("expected the cycle to be reported")
+	}
+
+	err := childCtx.Validate()
+	var ambiguous ErrorAmbiguousBinding
+	if !errors.As(err, &ambiguous) || ambiguous.Ty != reflect.TypeOf([]Handler{}) {
+		t.Errorf("expected ErrorAmbiguousBinding but got %v", err)
+	}
+
+}
+
+// CanInject reports the same errors that TryInject would, without
+// calling anything.
+func TestCanInject(t *testing.T) {
+
+	type Foo int
+	type Bar int
+	type Wibble int
+	type Unknown int
+
+	called := false
+
+	ctx := New()
+	ctx.Register(Foo(1))
+	ctx.Register(func(f Foo) Bar { called = true; return Bar(f) })
+	ctx.Register(func(f Foo, u Unknown) Wibble { called = true; return Wibble(f) })
+	ctx.Register(func(b CycleB) CycleA { called = true; return CycleA{} })
+	ctx.Register(func(a CycleA) CycleB { called = true; return CycleB{} })
+	ctx.RegisterGroup(func(b Bar) Thinger { called = true; return Thing(1) })
+
+	fns := []interface{}{
+		func(f Foo, b *Bar) {},
+		func(f Foo, things []Thinger) {},
+		func(f Foo, u *Unknown) {},
+		func(f Foo, w Wibble) {},
+		func(c CycleA) {},
+		"not a function",
+		// Unknown is the second argument of Wibble's function,
+		// but it's the first argument here which fails:
+		func(w Wibble, f Foo) {},
+	}
+
+	checkErrs := []error{}
+	for _, fn := range fns {
+		checkErrs = append(checkErrs, ctx.CanInject(fn))
+	}
+	if called {
+		t.Error("CanInject should not call registered functions")
+	}
+	if err := ctx.CanInject(fns[0]); err != nil {
+		t.Errorf("expected to be able to inject: %s", err)
+	}
+
+	for i, fn := range fns {
+		var injectErr error
+		withTimeout(t, func() {
+			injectErr = ctx.TryInject(fn)
+		})
+		if !reflect.DeepEqual(checkErrs[i], injectErr) {
+			t.Errorf("%d: CanInject returned %v but TryInject returned %v", i, checkErrs[i], injectErr)
+		}
+	}
+
+	err := ctx.Check(fns...)
+	validationErr, ok := err.(ErrorValidation)
+	if !ok || len(validationErr.Errors) != 5 {
+		t.Fatalf("expected 5 errors from Check but got %v", err)
+	}
+	if e, ok := validationErr.Errors[0].(ErrorCannotInject); !ok || e.Index != 2 {
+		t.Errorf("unexpected first error from Check: %s", validationErr.Errors[0])
+	}
+	if e, ok := checkErrs[6].(ErrorTypeNotRegistered); !ok || e.Pos != 1 {
+		t.Errorf("expected the first argument to be blamed: %v", checkErrs[6])
+	}
+	if err := ctx.Check(fns[0], fns[1]); err != nil {
+		t.Errorf("Check should succeed: %s", err)
+	}
+
+}
+
+// Graph describes what's registered and how things depend on each other.
+func TestGraph(t *testing.T) {
+
+	type Foo int
+	type Bar int
+	type Handler struct{}
+	type Unknown int
+
+	ctx := New()
+	ctx.Register(Foo(1))
+	ctx.Register(func(f Foo) Bar { return Bar(f) })
+
+	childCtx := ctx.Child()
+	childCtx.RegisterNamed("primary", &DB{})
+	childCtx.RegisterWith(func(b Bar, db Named[*DB, Primary], u Unknown) Handler { return Handler{} }, InGroup(), WithLifetime(Transient))
+
+	childCtx.Inject(func(b Bar) {})
+
+	g := childCtx.Graph()
+	expectedNodes := []GraphNode{
+		{ID: "n1", Type: "depends.Foo", Depth: 1, Initialised: true},
+		{ID: "n2", Type: "depends.Bar", Function: true, Lifetime: "Singleton", Depth: 1, Initialised: true},
+		{ID: "n3", Type: "depends.DB", Name: "primary", Depth: 0, Initialised: true},
+		{ID: "n4", Type: "depends.Handler", Group: true, Function: true, Lifetime: "Transient", Depth: 0},
+		{ID: "n5", Type: "depends.Unknown", Missing: true},
+	}
+	expectedEdges := []GraphEdge{
+		{From: "n2", To: "n1", Pos: 1},
+		{From: "n4", To: "n2", Pos: 1},
+		{From: "n4", To: "n3", Pos: 2},
+		{From: "n4", To: "n5", Pos: 3},
+	}
+	// Where things were registered is checked separately,
+	// since it depends on where this file lives:
+	for i, node := range g.Nodes {
+		if node.Missing != (node.RegisteredAt == "") || (!node.Missing && !strings.Contains(node.RegisteredAt, "depends_test.go:")) {
+			t.Errorf("unexpected RegisteredAt for %s: %q", node.Type, node.RegisteredAt)
+		}
+		g.Nodes[i].RegisteredAt = ""
+	}
+	if !reflect.DeepEqual(g.Nodes, expectedNodes) {
+		t.Errorf("unexpected nodes: %+v", g.Nodes)
+	}
+	if !reflect.DeepEqual(g.Edges, expectedEdges) {
+		t.Errorf("unexpected edges: %+v", g.Edges)
+	}
+
+	// The parent can't see the child's registrations:
+	if len(ctx.Graph().Nodes) != 2 {
+		t.Errorf("unexpected parent nodes: %+v", ctx.Graph().Nodes)
+	}
+
+	jsonOut := bytes.Buffer{}
+	if err := g.WriteJSON(&jsonOut); err != nil {
+		t.Fatalf("failed to write JSON: %s", err)
+	}
+	decoded := Graph{}
+	if err := json.Unmarshal(jsonOut.Bytes(), &decoded); err != nil || !reflect.DeepEqual(decoded, g) {
+		t.Errorf("JSON did not round trip: %v, %s", err, jsonOut.String())
+	}
+
+	dotOut := bytes.Buffer{}
+	if err := g.WriteDOT(&dotOut); err != nil {
+		t.Fatalf("failed to write DOT: %s", err)
+	}
+	dot := dotOut.String()
+	for _, expected := range []string{
+		"digraph depends {",
+		"subgraph cluster_1 {",
+		`n3 [label="depends.DB\nname: primary"];`,
+		`n4 [label="depends.Handler\ngroup\ntransient", style=dashed];`,
+		`n5 [label="depends.Unknown", color=red, fontcolor=red];`,
+		`n4 -> n3 [label="2"];`,
+	} {
+		if !strings.Contains(dot, expected) {
+			t.Errorf("expected DOT output to contain %s:\n%s", expected, dot)
+		}
+	}
+
+}
+
+// Build creates everything up front, running independent registered
+// functions concurrently.
+func TestBuild(t *testing.T) {
+
+	type A int
+	type B int
+	type C int
+	type T int
+	type S int
+
+	for _, parallelism := range []int{0, 1, 2, 4} {
+
+		mu := sync.Mutex{}
+		running, maxRunning := 0, 0
+		calls := []string{}
+		track := func(name string) func() {
+			mu.Lock()
+			calls = append(calls, name)
+			running++
+			if running > maxRunning {
+				maxRunning = running
+			}
+			mu.Unlock()
+			time.Sleep(10 * time.Millisecond)
+			return func() {
+				mu.Lock()
+				running--
+				mu.Unlock()
+			}
+		}
+
+		ctx := New()
+		ctx.Register(func() A { defer track("A")(); return A(1) })
+		ctx.Register(func() B { defer track("B")(); return B(2) })
+		ctx.Register(func(a A, b B) C { defer track("C")(); return C(int(a) + int(b)) })
+		ctx.RegisterWith(func() T { defer track("T")(); return T(1) }, WithLifetime(Transient))
+		childCtx := ctx.Child()
+		childCtx.RegisterWith(func(c C, t T) S { defer track("S")(); return S(c) }, WithLifetime(Scoped))
+
+		var err error
+		withTimeout(t, func() {
+			err = childCtx.Build(context.Background(), parallelism)
+		})
+		if err != nil {
+			t.Fatalf("%d: build failed: %s", parallelism, err)
+		}
+
+		// T is transient, so is only called in order to build S:
+		if len(calls) != 5 || calls[2] != "C" || calls[3] != "T" || calls[4] != "S" {
+			t.Errorf("%d: unexpected calls: %v", parallelism, calls)
+		}
+		expectedMax := 2
+		if parallelism < 2 {
+			expectedMax = 1
+		}
+		if maxRunning != expectedMax {
+			t.Errorf("%d: expected at most %d running at once but saw %d", parallelism, expectedMax, maxRunning)
+		}
+
+		// Nothing more is called when asking for things now:
+		childCtx.Inject(func(a A, b B, c C, s S) {})
+		if len(calls) != 5 {
+			t.Errorf("%d: unexpected calls after build: %v", parallelism, calls)
+		}
+
+	}
+
+	// Registrations that the child overrides aren't built:
+	type Missing int
+	dialed := false
+	ctx := New()
+	ctx.Register(func() B { dialed = true; return 0 })
+	ctx.Register(func(Missing) A { return 0 })
+	childCtx := ctx.Child()
+	childCtx.Register(A(1), B(2))
+	if err := childCtx.Build(context.Background(), 1); err != nil {
+		t.Errorf("expected the child to build: %s", err)
+	}
+	if dialed {
+		t.Error("B should not have been built")
+	}
+
+}
+
+// Build fails fast and reports errors, cycles and deadlines.
+func TestBuildErrors(t *testing.T) {
+
+	type A int
+	type B int
+	type C int
+
+	errA := errors.New("a failed")
+	cCalled := false
+
+	ctx := New()
+	ctx.Register(func() (A, error) { return 0, errA })
+	ctx.Register(func() B { return B(1) })
+	ctx.Register(func(a A, b B) C { cCalled = true; return C(1) })
+
+	err := ctx.Build(context.Background(), 2)
+	if _, ok := err.(ErrorBuildFailed); !ok || !errors.Is(err, errA) {
+		t.Errorf("expected ErrorBuildFailed wrapping the factory error but got %v", err)
+	}
+	if cCalled {
+		t.Error("C should not be built if A fails")
+	}
+
+	cycleCtx := New()
+	cycleCtx.Register(func(b CycleB) CycleA { return CycleA{} })
+	cycleCtx.Register(func(a CycleA) CycleB { return CycleB{} })
+	cycleCtx.Register(func(a CycleA) CycleC { return CycleC{} })
+	withTimeout(t, func() {
+		err = cycleCtx.Build(context.Background(), 2)
+	})
+	var cycleErr ErrorCircularInject
+	if !errors.As(err, &cycleErr) {
+		t.Errorf("expected ErrorCircularInject but got %v", err)
+	}
+
+	release := make(chan struct{})
+	defer close(release)
+	slowCtx := New()
+	slowCtx.Register(func() A { <-release; return A(1) })
+	deadline, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	withTimeout(t, func() {
+		err = slowCtx.Build(deadline, 1)
+	})
+	if _, ok := err.(ErrorBuildFailed); !ok || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected ErrorBuildFailed wrapping the deadline error but got %v", err)
+	}
+
+}
+
+// Registered functions can ask for a context.Context, which is
+// the one handed to TryInjectContext.
+func TestInjectContext(t *testing.T) {
+
+	type key struct{}
+	type Conn struct{ Value interface{} }
+
+	ctx := New()
+	ctx.RegisterWith(func(c context.Context) Conn {
+		return Conn{c.Value(key{})}
+	}, WithLifetime(Transient))
+
+	c := context.WithValue(context.Background(), key{}, "hello")
+	err := ctx.TryInjectContext(c, func(conn Conn, c2 context.Context) {
+		if conn.Value != "hello" || c2.Value(key{}) != "hello" {
+			t.Error("context was not handed over")
+		}
+	})
+	if err != nil {
+		t.Errorf("injection failed: %s", err)
+	}
+
+	ctx.Inject(func(conn Conn, c2 context.Context) {
+		if conn.Value != nil || c2 != context.Background() {
+			t.Error("context.Background() should be used by Inject")
+		}
+	})
+
+	if err := ctx.CanInject(func(c context.Context, conn Conn) {}); err != nil {
+		t.Errorf("context.Context should always be injectable: %s", err)
+	}
+
+}
+
+// Cancelling stops injection, and values that were being created are
+// thrown away rather than kept.
+func TestInjectContextCancel(t *testing.T) {
+
+	type Conn struct{}
+	type Slow struct{}
+
+	calls := 0
+	cleanups := 0
+
+	ctx := New()
+	ctx.Register(func(c context.Context) (Conn, func(), error) {
+		calls++
+		select {
+		case <-c.Done():
+			return Conn{}, nil, c.Err()
+		case <-time.After(time.Millisecond):
+			return Conn{}, func() { cleanups++ }, nil
+		}
+	})
+
+	// Already cancelled; nothing is called:
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	called := false
+	err := ctx.TryInjectContext(cancelled, func(c Conn) { called = true })
+	if !errors.Is(err, context.Canceled) || called || calls != 0 {
+		t.Errorf("expected ErrorCanceled without calling anything but got %v", err)
+	}
+	err = ctx.TryInjectContext(cancelled, func() { called = true })
+	if _, ok := err.(ErrorCanceled); !ok || called {
+		t.Errorf("expected ErrorCanceled without calling anything but got %v", err)
+	}
+
+	// Cancelled during creation; the result is thrown away:
+	midway, cancel := context.WithCancel(context.Background())
+	ctx.Register(func(conn Conn) Slow {
+		cancel()
+		return Slow{}
+	})
+	err = ctx.TryInjectContext(midway, func(s Slow) { called = true })
+	cancelErr, ok := err.(ErrorCanceled)
+	if !ok || called || len(cancelErr.Chain) != 1 || cancelErr.Chain[0] != reflect.TypeOf(Slow{}) {
+		t.Errorf("expected ErrorCanceled but got %v", err)
+	}
+
+	// Conn was created successfully and so is kept; Slow was not:
+	ctx.Inject(func(s Slow) {})
+	if calls != 1 {
+		t.Errorf("expected Conn to be created once but was created %d times", calls)
+	}
+	if err := ctx.Close(); err != nil || cleanups != 1 {
+		t.Errorf("expected Conn to be cleaned up on close: %v, %d", err, cleanups)
+	}
+
+}
+
+// Cancelling stops waiting on values being created elsewhere.
+func TestInjectContextCancelWhileWaiting(t *testing.T) {
+
+	type Slow struct{}
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+
+	ctx := New()
+	ctx.Register(func() Slow {
+		close(started)
+		<-release
+		return Slow{}
+	})
+
+	go ctx.Inject(func(s Slow) {})
+	<-started
+
+	c, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	var err error
+	withTimeout(t, func() {
+		err = ctx.TryInjectContext(c, func(s Slow) {})
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected ErrorCanceled but got %v", err)
+	}
+
+	close(release)
+	withTimeout(t, func() {
+		err = ctx.TryInjectContext(context.Background(), func(s Slow) {})
+	})
+	if err != nil {
+		t.Errorf("injection should now succeed: %s", err)
+	}
+
+}
+
+func assertPanics(t *testing.T, name string, fn func()) {
+	t.Helper()
+	defer func() {
+		if recover() == nil {
+			t.Errorf("%s: expected a panic", name)
+		}
+	}()
+	fn()
+}
+
+// withTimeout fails the test rather than hanging if fn deadlocks.
+func withTimeout(t *testing.T, fn func()) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn()
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out; possible deadlock")
+	}
+}
+
+// A failing function has its failure remembered, rather than leaving
+// behind a zero value, and isn't called again.
+func TestFactoryFailureRemembered(t *testing.T) {
+
+	type Conn int
+	type Flaky int
+
+	ctx := New()
+
+	calls := 0
+	ctx.Register(func() (Conn, error) {
+		calls++
+		return 0, errors.New("no connection")
+	})
+	ctx.Register(func() Flaky {
+		calls++
+		panic("oops")
+	})
+
+	for i := 0; i < 3; i++ {
+		err := ctx.TryInject(func(Conn) {})
+		var failed ErrorFactoryFailed
+		if !errors.As(err, &failed) || failed.Err.Error() != "no connection" {
+			t.Fatalf("expected the same ErrorFactoryFailed each time, got: %v", err)
+		}
+		if _, ok := ctx.TryInject(func(Flaky) {}).(ErrorPanicInFunction); !ok {
+			t.Fatal("expected the same ErrorPanicInFunction each time")
+		}
+	}
+	if calls != 2 {
+		t.Errorf("failing functions should be called once each, not %d times", calls)
+	}
+
+}
+
+// Failures caused by dependencies aren't remembered, so registering
+// the missing dependency later fixes things.
+func TestDependencyFailureNotRemembered(t *testing.T) {
+
+	type Config string
+	type Conn string
+
+	ctx := New()
+	ctx.Register(func(c Config) Conn { return Conn(c) })
+
+	if _, ok := ctx.TryInject(func(Conn) {}).(ErrorTypeNotRegistered); !ok {
+		t.Fatal("expected ErrorTypeNotRegistered")
+	}
+
+	ctx.Register(Config("db"))
+	ctx.Inject(func(c Conn) {
+		if c != "db" {
+			t.Errorf("wrong value: %s", c)
+		}
+	})
+
+}
+
+// Functions registered with a RetryPolicy are called again until they
+// succeed or run out of attempts.
+func TestRetryPolicy(t *testing.T) {
+
+	type Conn int
+	type Broken int
+
+	ctx := New()
+
+	connCalls := 0
+	ctx.RegisterWith(func() (Conn, error) {
+		connCalls++
+		if connCalls < 3 {
+			return 0, errors.New("not yet")
+		}
+		return Conn(connCalls), nil
+	}, WithRetry(RetryPolicy{MaxAttempts: 5}))
+
+	brokenCalls := 0
+	ctx.RegisterWith(func() (Broken, error) {
+		brokenCalls++
+		return 0, errors.New("never")
+	}, WithRetry(RetryPolicy{MaxAttempts: 3}))
+
+	for i := 0; i < 2; i++ {
+		if ctx.TryInject(func(Conn) {}) == nil {
+			t.Fatal("expected an error while the function is failing")
+		}
+	}
+	for i := 0; i < 2; i++ {
+		ctx.Inject(func(c Conn) {
+			if c != 3 {
+				t.Errorf("wrong value: %d", c)
+			}
+		})
+	}
+	if connCalls != 3 {
+		t.Errorf("expected 3 calls, got %d", connCalls)
+	}
+
+	for i := 0; i < 5; i++ {
+		if ctx.TryInject(func(Broken) {}) == nil {
+			t.Fatal("expected an error")
+		}
+	}
+	if brokenCalls != 3 {
+		t.Errorf("expected 3 calls before giving up, got %d", brokenCalls)
+	}
+
+}
+
+// While backing off, the last failure is handed back without calling
+// the function again.
+func TestRetryPolicyBackoff(t *testing.T) {
+
+	type Conn int
+
+	ctx := New()
+
+	calls := 0
+	ctx.RegisterWith(func() (Conn, error) {
+		calls++
+		if calls == 1 {
+			return 0, errors.New("not yet")
+		}
+		return 1, nil
+	}, WithRetry(RetryPolicy{Backoff: 50 * time.Millisecond}))
+
+	for i := 0; i < 2; i++ {
+		if ctx.TryInject(func(Conn) {}) == nil {
+			t.Fatal("expected an error while backing off")
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("function should not be called while backing off, got %d calls", calls)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if err := ctx.TryInject(func(Conn) {}); err != nil {
+		t.Errorf("expected success after backing off: %s", err)
+	}
+
+	policy := RetryPolicy{Backoff: time.Second, MaxBackoff: 5 * time.Second}
+	for failures, want := range []time.Duration{0, 1, 2, 4, 5, 5} {
+		if failures == 0 {
+			continue
+		}
+		if got := policy.backoff(failures); got != want*time.Second {
+			t.Errorf("backoff after %d failures: expected %s, got %s", failures, want*time.Second, got)
+		}
+	}
+
+}
+
+// Call and TryCall hand back whatever the function returns, and a
+// trailing error is handed back as the error.
+func TestCall(t *testing.T) {
+
+	type Port int
+
+	ctx := New()
+	ctx.Register(Port(8080))
+
+	results := ctx.Call(func(p Port) (string, int) {
+		return "port", int(p)
+	})
+	if !reflect.DeepEqual(results, []interface{}{"port", 8080}) {
+		t.Errorf("wrong results: %v", results)
+	}
+
+	results, err := ctx.TryCall(func(p Port) (Port, error) {
+		return p + 1, nil
+	})
+	if err != nil || !reflect.DeepEqual(results, []interface{}{Port(8081)}) {
+		t.Errorf("wrong results: %v, %v", results, err)
+	}
+
+	failure := errors.New("cannot listen")
+	results, err = ctx.TryCall(func(p Port) (Port, error) {
+		return p, failure
+	})
+	if err != failure || !reflect.DeepEqual(results, []interface{}{Port(8080)}) {
+		t.Errorf("expected the function's error to be handed back: %v, %v", results, err)
+	}
+
+	if err := ctx.TryInject(func(Port) error { return failure }); err != failure {
+		t.Errorf("expected TryInject to hand back the function's error, got: %v", err)
+	}
+	if err := ctx.TryInject(func(Port) error { return nil }); err != nil {
+		t.Errorf("expected no error, got: %v", err)
+	}
+
+	if _, err := ctx.TryCall(func(string) {}); err == nil {
+		t.Error("expected an error for an unregistered type")
+	}
+
+	assertPanics(t, "Call with failing function", func() {
+		ctx.Call(func() error { return failure })
+	})
+
+}
+
+// Arguments handed to InjectWith are used in preference to registered
+// values, either by type or by position.
+func TestInjectWith(t *testing.T) {
+
+	type RequestID string
+	type DB string
+
+	ctx := New()
+	ctx.Register(DB("postgres"))
+	ctx.Register(RequestID("registered"))
+
+	ctx.InjectWith(func(db DB, id RequestID, n int) {
+		if db != "postgres" || id != "abc" || n != 3 {
+			t.Errorf("wrong values: %s %s %d", db, id, n)
+		}
+	}, 3, RequestID("abc"))
+
+	ctx.InjectWith(func(a, b string, db DB) {
+		if a != "first" || b != "second" || db != "override" {
+			t.Errorf("wrong values: %s %s %s", a, b, db)
+		}
+	}, At(2, "second"), "first", At(3, DB("override")))
+
+	// Values are matched to interfaces they implement, and
+	// nil can be handed over by position:
+	ctx.InjectWith(func(th Thinger, db *DB) {
+		if th.GetThings() != 4 || db != nil {
+			t.Error("wrong values")
+		}
+	}, Thing(4), At(2, nil))
+
+	cases := map[string][]interface{}{
+		"no match":          {1.5},
+		"too many":          {"a", "b"},
+		"out of range":      {At(3, "a")},
+		"wrong type":        {At(1, 2)},
+		"position repeated": {At(1, "a"), At(1, "b")},
+		"nil by type":       {nil},
+		"nil non-pointer":   {At(1, nil)},
+	}
+	for name, args := range cases {
+		called := false
+		err := ctx.TryInjectWith(func(s string, db DB) { called = true }, args...)
+		if _, ok := err.(ErrorArgumentNotUsed); !ok || called {
+			t.Errorf("%s: expected ErrorArgumentNotUsed, got: %v", name, err)
+		}
+	}
+
+	if err := ctx.TryInjectWith(func(string, float64) {}, "a"); err == nil {
+		t.Error("expected unsupplied and unregistered arguments to fail")
+	}
+
+	assertPanics(t, "At(0)", func() { At(0, "a") })
+
+}
+
+// Structs embedding In have each of their fields injected.
+func TestInStruct(t *testing.T) {
+
+	type DB string
+	type Cache string
+	type Logger string
+
+	type Params struct {
+		In
+		DB      DB
+		Replica *DB    `depends:"name=replica"`
+		Cache   Cache  `depends:"optional"`
+		Logger  Logger `depends:"optional"`
+		Ignored int    `depends:"-"`
+		private int
+	}
+
+	ctx := New()
+	ctx.Register(DB("primary"), Logger("log"))
+	ctx.RegisterNamed("replica", DB("replica"))
+
+	ctx.Register(func(p Params) string {
+		return string(p.DB) + " " + string(*p.Replica) + " \"" + string(p.Cache) + "\" " + string(p.Logger)
+	})
+
+	ctx.Inject(func(s string, p Params) {
+		if s != `primary replica "" log` {
+			t.Errorf("wrong value: %s", s)
+		}
+		if p.DB != "primary" || p.Ignored != 0 || p.private != 0 {
+			t.Errorf("wrong params: %+v", p)
+		}
+	})
+
+	if err := ctx.Validate(); err != nil {
+		t.Errorf("expected no validation errors: %s", err)
+	}
+	if err := ctx.CanInject(func(Params) {}); err != nil {
+		t.Errorf("expected Params to be injectable: %s", err)
+	}
+
+	// Missing fields that aren't optional cause an error:
+	type Missing struct {
+		In
+		DB    DB
+		Float float64
+	}
+	err := ctx.TryInject(func(Missing) {})
+	if e, ok := err.(ErrorTypeNotRegistered); !ok || e.Ty != reflect.TypeOf(float64(0)) || e.Pos != 1 {
+		t.Errorf("expected ErrorTypeNotRegistered for float64, got: %v", err)
+	}
+	if _, ok := ctx.CanInject(func(Missing) {}).(ErrorTypeNotRegistered); !ok {
+		t.Error("expected CanInject to spot the missing field")
+	}
+
+	// As do bad tags:
+	type BadTag struct {
+		In
+		DB DB `depends:"nope"`
+	}
+	if _, ok := ctx.TryInject(func(BadTag) {}).(ErrorInjectStruct); !ok {
+		t.Error("expected ErrorInjectStruct for a bad tag")
+	}
+
+}
+
+// Functions returning structs embedding Out provide each of their fields.
+func TestOutStruct(t *testing.T) {
+
+	type DB string
+	type Cache struct{ Size int }
+
+	type Results struct {
+		Out
+		DB      DB
+		Replica DB `depends:"name=replica"`
+		Cache   *Cache
+		Ignored int `depends:"-"`
+	}
+
+	ctx := New()
+
+	calls := 0
+	cleaned := 0
+	ctx.Register(func() (Results, func()) {
+		calls++
+		return Results{DB: "primary", Replica: "replica", Cache: &Cache{10}}, func() { cleaned++ }
+	})
+
+	for i := 0; i < 2; i++ {
+		ctx.Inject(func(db DB, replica Named[DB, ReplicaName], cache *Cache) {
+			if db != "primary" || replica.Value != "replica" || cache.Size != 10 {
+				t.Errorf("wrong values: %s %s %d", db, replica.Value, cache.Size)
+			}
+		})
+	}
+	if calls != 1 {
+		t.Errorf("expected the function to be called once, got %d", calls)
+	}
+
+	if err := ctx.TryInject(func(int) {}); err == nil {
+		t.Error("fields tagged - should not be registered")
+	}
+	if err := ctx.TryInject(func(Results) {}); err == nil {
+		t.Error("the struct itself should not be registered")
+	}
+
+	// Transient functions are called for every field asked for:
+	type Counter int
+	type Pair struct {
+		Out
+		A Counter `depends:"name=a"`
+		B Counter `depends:"name=b"`
+	}
+	n := 0
+	ctx.RegisterWith(func() Pair {
+		n++
+		return Pair{A: Counter(n), B: Counter(n)}
+	}, WithLifetime(Transient))
+	ctx.Inject(func(a Named[Counter, AName], b Named[Counter, BName]) {
+		if a.Value == b.Value {
+			t.Error("expected transient fields to be created separately")
+		}
+	})
+
+	if err := ctx.Close(); err != nil || cleaned != 1 {
+		t.Errorf("expected the cleanup to run once: %v, %d", err, cleaned)
+	}
+
+	assertPanics(t, "As", func() {
+		ctx.RegisterAs(func() Results { return Results{} }, (*Thinger)(nil))
+	})
+	assertPanics(t, "no fields", func() {
+		ctx.Register(func() struct{ Out } { return struct{ Out }{} })
+	})
+	assertPanics(t, "optional", func() {
+		type Opt struct {
+			Out
+			DB DB `depends:"optional"`
+		}
+		ctx.Register(func() Opt { return Opt{} })
+	})
+
+	// Fields which need closing are closed along with the Context:
+	type Conns struct {
+		Out
+		Primary *closeRecorder `depends:"name=primary"`
+		Replica *closeRecorder `depends:"name=replica"`
+		Port    int
+	}
+	closed := []string{}
+	closeCtx := New()
+	closeCtx.Register(func() Conns {
+		return Conns{
+			Primary: &closeRecorder{"primary", &closed, nil},
+			Replica: &closeRecorder{"replica", &closed, nil},
+		}
+	})
+	closeCtx.Inject(func(Named[*closeRecorder, Primary]) {})
+	if err := closeCtx.Close(); err != nil {
+		t.Errorf("close should not fail: %s", err)
+	}
+	if !reflect.DeepEqual(closed, []string{"replica", "primary"}) {
+		t.Errorf("expected each field to be closed once: %v", closed)
+	}
+
+}
+
+type AName struct{}
+
+func (AName) DependsName() string { return "a" }
+
+type BName struct{}
+
+func (BName) DependsName() string { return "b" }
+
+// Optional values are provided if registered, and left as
+// the zero value if not.
+func TestOptional(t *testing.T) {
+
+	type Tracer struct{ Name string }
+	type Cache string
+	type Needy string
+
+	ctx := New()
+	ctx.Register(&Tracer{"jaeger"})
+	ctx.RegisterNamed("replica", Cache("replica"))
+	ctx.Register(func(c Cache) Needy { return Needy(c) })
+	ctx.Register(func(c Optional[Cache]) string {
+		if c.OK {
+			return "cached"
+		}
+		return "uncached"
+	})
+
+	ctx.Inject(func(tr Optional[*Tracer], c Optional[Cache], r Optional[Named[Cache, ReplicaName]], s string) {
+		if tracer, ok := tr.Get(); !ok || tracer.Name != "jaeger" {
+			t.Error("expected the tracer to be provided")
+		}
+		if c.OK || c.Value != "" {
+			t.Error("expected no cache to be provided")
+		}
+		if !r.OK || r.Value.Value != "replica" {
+			t.Error("expected the named cache to be provided")
+		}
+		if s != "uncached" {
+			t.Errorf("wrong value: %s", s)
+		}
+	})
+
+	if c, err := Get[Optional[Cache]](ctx); err != nil || c.OK {
+		t.Errorf("expected an empty Optional: %v, %v", c, err)
+	}
+
+	// Optional types that are registered but can't be provided still fail:
+	if _, ok := ctx.TryInject(func(Optional[Needy]) {}).(ErrorTypeNotRegistered); !ok {
+		t.Error("expected the missing dependency of Needy to be reported")
+	}
+
+	if err := ctx.CanInject(func(Optional[Cache], Optional[float64]) {}); err != nil {
+		t.Errorf("expected optional values to be injectable: %s", err)
+	}
+	if _, ok := ctx.CanInject(func(Optional[Needy]) {}).(ErrorTypeNotRegistered); !ok {
+		t.Error("expected CanInject to spot the missing dependency of Needy")
+	}
+
+	// Structs embedding an Optional type are just ordinary types:
+	type MaybeTracer struct{ Optional[*Tracer] }
+	if _, ok := ctx.TryInject(func(MaybeTracer) {}).(ErrorTypeNotRegistered); !ok {
+		t.Error("expected ErrorTypeNotRegistered for a struct embedding Optional")
+	}
+	if _, ok := ctx.CanInject(func(MaybeTracer) {}).(ErrorTypeNotRegistered); !ok {
+		t.Error("expected CanInject to agree with TryInject")
+	}
+
+	graph := ctx.Graph()
+	for _, node := range graph.Nodes {
+		if node.Missing && node.Type != reflect.TypeOf(Cache("")).String() {
+			t.Errorf("unexpected missing node: %+v", node)
+		}
+	}
+
+	// Struct fields can be optional too:
+	var s struct {
+		Tracer   *Tracer
+		Cache    Cache `depends:"optional"`
+		Optional Optional[Cache]
+		Replica  Optional[Named[Cache, ReplicaName]]
+	}
+	ctx.InjectStruct(&s)
+	if s.Tracer == nil || s.Cache != "" || s.Optional.OK || s.Replica.Value.Value != "replica" {
+		t.Errorf("wrong values: %+v", s)
+	}
+
+}
+
+// Providers put off creating values until they're needed.
+func TestProvider(t *testing.T) {
+
+	type Expensive struct{ N int }
+	type Counter int
+
+	ctx := New()
+
+	calls := 0
+	ctx.Register(func() *Expensive {
+		calls++
+		return &Expensive{calls}
+	})
+	n := 0
+	ctx.RegisterWith(func() Counter {
+		n++
+		return Counter(n)
+	}, WithLifetime(Transient))
+	ctx.Register(func() (float64, error) {
+		return 0, errors.New("no floats")
+	})
+
+	ctx.Inject(func(p Provider[*Expensive], get func() (*Expensive, error), counter Provider[Counter]) {
+		if calls != 0 {
+			t.Fatal("the function should not be called until the value is needed")
+		}
+		e1 := p.MustGet()
+		e2, err := get()
+		if err != nil || e1 != e2 || calls != 1 {
+			t.Errorf("expected the same singleton each time: %v", err)
+		}
+		if counter.MustGet() == counter.MustGet() {
+			t.Error("expected transient values to be created on each Get")
+		}
+	})
+
+	ctx.Inject(func(get func() (float64, error)) {
+		if _, err := get(); err == nil {
+			t.Error("expected the function's error")
+		}
+	})
+
+	if _, ok := ctx.TryInject(func(Provider[string]) {}).(ErrorTypeNotRegistered); !ok {
+		t.Error("expected ErrorTypeNotRegistered for a Provider of an unregistered type")
+	}
+	if _, ok := ctx.CanInject(func(func() (string, error)) {}).(ErrorTypeNotRegistered); !ok {
+		t.Error("expected CanInject to spot the unregistered type")
+	}
+
+	// Struct fields can be Providers too:
+	var s struct {
+		Expensive Provider[*Expensive]
+		Get       func() (*Expensive, error)
+	}
+	ctx.InjectStruct(&s)
+	if e, err := s.Get(); err != nil || e != s.Expensive.MustGet() {
+		t.Errorf("expected struct fields to provide the singleton: %v", err)
+	}
+
+	// Structs embedding a Provider are just ordinary types:
+	type LazyExpensive struct{ Provider[*Expensive] }
+	if _, ok := ctx.TryInject(func(LazyExpensive) {}).(ErrorTypeNotRegistered); !ok {
+		t.Error("expected ErrorTypeNotRegistered for a struct embedding Provider")
+	}
+	if _, ok := ctx.CanInject(func(LazyExpensive) {}).(ErrorTypeNotRegistered); !ok {
+		t.Error("expected CanInject to agree with TryInject")
+	}
+	var embedded struct{ Lazy LazyExpensive }
+	if err := ctx.TryInjectStruct(&embedded); err == nil {
+		t.Error("expected an error injecting a struct embedding Provider")
+	}
+
+	var zero Provider[int]
+	if _, err := zero.Get(); err == nil {
+		t.Error("expected an error from an empty Provider")
+	}
+
+}
+
+type LazyA struct{ B Provider[*LazyB] }
+type LazyB struct{ A *LazyA }
+
+// Asking for a Provider breaks what would otherwise be a cycle.
+func TestProviderCycle(t *testing.T) {
+
+	ctx := New()
+	ctx.Register(func(b Provider[*LazyB]) *LazyA { return &LazyA{b} })
+	ctx.Register(func(a *LazyA) *LazyB { return &LazyB{a} })
+
+	if err := ctx.Validate(); err != nil {
+		t.Errorf("expected no validation errors: %s", err)
+	}
+	if err := ctx.Check(func(*LazyA, *LazyB) {}); err != nil {
+		t.Errorf("expected no errors: %s", err)
+	}
+
+	graph := ctx.Graph()
+	lazy := 0
+	for _, edge := range graph.Edges {
+		if edge.Lazy {
+			lazy++
+		}
+	}
+	if lazy != 1 {
+		t.Errorf("expected one lazy edge, got %d", lazy)
+	}
+	buf := &bytes.Buffer{}
+	graph.WriteDOT(buf)
+	if !strings.Contains(buf.String(), "style=dotted") {
+		t.Error("expected the lazy edge to be dotted")
+	}
+
+	withTimeout(t, func() {
+		if err := ctx.Build(context.Background(), 2); err != nil {
+			t.Errorf("build failed: %s", err)
+		}
+		ctx.Inject(func(a *LazyA, b *LazyB) {
+			if a.B.MustGet() != b || b.A != a {
+				t.Error("expected the same values either way round")
+			}
+		})
+	})
+
+}
+
+// Asking for the value from a Provider while creating the value
+// that it's needed for is still a cycle, rather than a deadlock.
+func TestProviderCycleWhileCreating(t *testing.T) {
+
+	ctx := New()
+	ctx.Register(func(b Provider[*LazyB]) (*LazyA, error) {
+		_, err := b.Get()
+		return &LazyA{b}, err
+	})
+	ctx.Register(func(a *LazyA) *LazyB { return &LazyB{a} })
+
+	withTimeout(t, func() {
+		err := ctx.TryInject(func(*LazyA) {})
+		var cycle ErrorCircularInject
+		if !errors.As(err, &cycle) {
+			t.Fatalf("expected ErrorCircularInject, got: %v", err)
+		}
+		if got := chainString(cycle.Chain); !strings.Contains(got, "LazyA -> LazyB -> LazyA") {
+			t.Errorf("wrong chain: %s", got)
+		}
+	})
+
+}
+
+type Store interface {
+	Get() string
+}
+
+type baseStore string
+
+func (s baseStore) Get() string { return string(s) }
+
+type wrappedStore struct {
+	inner  Store
+	prefix string
+}
+
+func (s wrappedStore) Get() string { return s.prefix + s.inner.Get() }
+
+// Decorators wrap whatever is registered, on the same Context
+// or a parent, without replacing it.
+func TestDecorate(t *testing.T) {
+
+	type Prefix string
+
+	ctx := New()
+	ctx.RegisterAs(baseStore("db"), (*Store)(nil))
+	ctx.Register(Prefix("cached:"))
+
+	calls := 0
+	ctx.Decorate(func(s Store, p Prefix) Store {
+		calls++
+		return wrappedStore{s, string(p)}
+	})
+
+	child := ctx.Child()
+	child.Register(Prefix("logged:"))
+	child.Decorate(func(s Store, p Prefix) Store {
+		return wrappedStore{s, string(p)}
+	})
+
+	ctx.Inject(func(s Store) {
+		if s.Get() != "cached:db" {
+			t.Errorf("wrong value: %s", s.Get())
+		}
+	})
+	child.Inject(func(s Store) {
+		if s.Get() != "logged:cached:db" {
+			t.Errorf("wrong value: %s", s.Get())
+		}
+	})
+	child.Child().Inject(func(s Store) {
+		if s.Get() != "logged:cached:db" {
+			t.Errorf("wrong value: %s", s.Get())
+		}
+	})
+	if calls != 1 {
+		t.Errorf("expected the decorator to be called once, got %d", calls)
+	}
+
+	// Decorators keep applying if the type is registered again:
+	other := New()
+	other.RegisterAs(baseStore("db"), (*Store)(nil))
+	other.Decorate(func(s Store) Store { return wrappedStore{s, "cached:"} })
+	other.RegisterAs(baseStore("cache"), (*Store)(nil))
+	other.Inject(func(s Store) {
+		if s.Get() != "cached:cache" {
+			t.Errorf("wrong value: %s", s.Get())
+		}
+	})
+
+	if err := child.Validate(); err != nil {
+		t.Errorf("expected no validation errors: %s", err)
+	}
+	if err := child.CanInject(func(Store) {}); err != nil {
+		t.Errorf("expected Store to be injectable: %s", err)
+	}
+
+	graph := child.Graph()
+	decorators := 0
+	for _, node := range graph.Nodes {
+		if node.Decorator {
+			decorators++
+		}
+	}
+	if decorators != 2 {
+		t.Errorf("expected 2 decorator nodes, got %d", decorators)
+	}
+
+	assertPanics(t, "not registered", func() { ctx.Decorate(func(f float64) float64 { return f }) })
+	assertPanics(t, "no args", func() { ctx.Decorate(func() Store { return nil }) })
+	assertPanics(t, "wrong return", func() { ctx.Decorate(func(s Store) int { return 1 }) })
+
+}
+
+// Decorators take on the Lifetime of whatever they decorate.
+func TestDecorateLifetime(t *testing.T) {
+
+	type Counter int
+
+	ctx := New()
+	n := 0
+	ctx.RegisterWith(func() Counter {
+		n++
+		return Counter(n)
+	}, WithLifetime(Scoped))
+
+	decorated := 0
+	ctx.Decorate(func(c Counter) Counter {
+		decorated++
+		return c * 10
+	})
+
+	a, b := ctx.Child(), ctx.Child()
+	for i := 0; i < 2; i++ {
+		a.Inject(func(c Counter) {
+			if c != 10 {
+				t.Errorf("wrong value: %d", c)
+			}
+		})
+		b.Inject(func(c Counter) {
+			if c != 20 {
+				t.Errorf("wrong value: %d", c)
+			}
+		})
+	}
+	if decorated != 2 {
+		t.Errorf("expected the decorator to be called once per Context, got %d", decorated)
+	}
+
+	// A decorator asking for what it decorates as a normal
+	// argument is a cycle:
+	ctx.Decorate(func(c Counter, again Counter) Counter { return c })
+	withTimeout(t, func() {
+		if _, ok := ctx.TryInject(func(Counter) {}).(ErrorCircularInject); !ok {
+			t.Error("expected ErrorCircularInject")
+		}
+	})
+
+}
+
+// Modules make their registrations, and those of any modules they
+// include, when installed.
+func TestModules(t *testing.T) {
+
+	type Logger struct{ Lines []string }
+	type DB string
+	type Server string
+
+	loggerCalls := 0
+	logging := NewModule("logging", Provides(func() *Logger {
+		loggerCalls++
+		return &Logger{}
+	}))
+	database := NewModule("database",
+		Includes(logging),
+		Provides(func(l *Logger) DB {
+			l.Lines = append(l.Lines, "db")
+			return "postgres"
+		}),
+		Decorates(func(db DB) DB { return db + "+logged" }),
+	)
+	server := NewModule("server",
+		Includes(logging, database),
+		ProvidesWith(func(db DB) Server { return Server("serving " + db) }, WithLifetime(Scoped)),
+	)
+
+	if server.Name() != "server" {
+		t.Errorf("wrong name: %s", server.Name())
+	}
+
+	ctx := New()
+	ctx.Install(server)
+	ctx.Install(logging)
+	ctx.Child().Install(database)
+
+	ctx.Inject(func(s Server, l *Logger) {
+		if s != "serving postgres+logged" {
+			t.Errorf("wrong value: %s", s)
+		}
+		if len(l.Lines) != 1 {
+			t.Errorf("wrong lines: %v", l.Lines)
+		}
+	})
+	if loggerCalls != 1 {
+		t.Errorf("expected one logger, got %d", loggerCalls)
+	}
+
+	graph := ctx.Graph()
+	for _, node := range graph.Nodes {
+		if node.Type == reflect.TypeOf(Server("")).String() && node.Module != "server" {
+			t.Errorf("expected the module to be recorded: %+v", node)
+		}
+	}
+
+	// Two modules with the same name can't both be installed,
+	// and nothing is installed if they would be:
+	other := NewModule("other", Provides(1.5))
+	imposter := NewModule("logging", Provides("imposter"))
+	err := ctx.Child().TryInstall(other, imposter)
+	if e, ok := err.(ErrorDuplicateModule); !ok || e.Name != "logging" {
+		t.Errorf("expected ErrorDuplicateModule, got: %v", err)
+	}
+	if err := New().TryInstall(NewModule("a", Includes(imposter)), logging); err == nil {
+		t.Error("expected ErrorDuplicateModule")
+	}
+	if err := ctx.TryInject(func(float64) {}); err == nil {
+		t.Error("nothing should have been installed")
+	}
+	assertPanics(t, "Install", func() { New().Install(logging, imposter) })
+	assertPanics(t, "NewModule", func() { NewModule("") })
+
+}
+
+// Errors about registrations made by modules mention the module.
+func TestModuleErrors(t *testing.T) {
+
+	type Conn string
+
+	ctx := New()
+	ctx.Install(NewModule("database",
+		Provides(func() (Conn, error) { return "", errors.New("refused") }),
+		Provides(func(float64) int { return 1 }),
+		Provides(func() string { panic("oops") }),
+		Provides(func(c CycleB) CycleA { return CycleA(c) }),
+		Provides(func(c CycleA) CycleB { return CycleB(c) }),
+	))
+
+	err := ctx.TryInject(func(int) {})
+	if e, ok := err.(ErrorTypeNotRegistered); !ok || e.Module != "database" || !strings.Contains(err.Error(), "module 'database'") {
+		t.Errorf("expected the module to be mentioned: %v", err)
+	}
+	if err := ctx.CanInject(func(int) {}); err.(ErrorTypeNotRegistered).Module != "database" {
+		t.Errorf("expected CanInject to mention the module: %v", err)
+	}
+	err = ctx.TryInject(func(string) {})
+	if e, ok := err.(ErrorPanicInFunction); !ok || e.Module != "database" || !strings.Contains(err.Error(), "module 'database'") {
+		t.Errorf("expected the module to be mentioned: %v", err)
+	}
+	err = ctx.TryInject(func(CycleA) {})
+	if e, ok := err.(ErrorCircularInject); !ok || !reflect.DeepEqual(e.Modules, []string{"database", "database", "database"}) || !strings.Contains(err.Error(), "module 'database'") {
+		t.Errorf("expected the module to be mentioned: %v", err)
+	}
+
+	err = ctx.TryInject(func(Conn) {})
+	var failed ErrorFactoryFailed
+	if !errors.As(err, &failed) || failed.Module != "database" || !strings.Contains(err.Error(), "module 'database'") {
+		t.Errorf("expected the module to be mentioned: %v", err)
+	}
+
+	err = ctx.Validate()
+	var unsatisfied ErrorUnsatisfiedDependency
+	if !errors.As(err, &unsatisfied) || unsatisfied.Module != "database" || !strings.Contains(err.Error(), "module 'database'") {
+		t.Errorf("expected the module to be mentioned: %v", err)
+	}
+
+}
+
+// Duplicate registrations are allowed by default, but can be
+// made to panic or warn instead.
+func TestDuplicatePolicy(t *testing.T) {
+
+	type Port int
+
+	ctx := New()
+	ctx.Register(Port(1))
+	ctx.Register(Port(2))
+	ctx.Inject(func(p Port) {
+		if p != 2 {
+			t.Errorf("expected the later registration to be used, got %d", p)
+		}
+	})
+
+	strict := New()
+	strict.SetDuplicatePolicy(ErrorOnDuplicates)
+	strict.Register(Port(1))
+	strict.RegisterNamed("admin", Port(2))
+	strict.RegisterGroup(Port(3), Port(4))
+
+	var dup ErrorDuplicateRegistration
+	func() {
+		defer func() { dup, _ = recover().(ErrorDuplicateRegistration) }()
+		strict.Register(func() Port { return 5 })
+	}()
+	if dup.Ty != reflect.TypeOf(Port(0)) || strings.Count(dup.Error(), "depends_test.go:") != 2 {
+		t.Errorf("expected both call sites to be named: %v", dup)
+	}
+	assertPanics(t, "named duplicate", func() { strict.RegisterNamed("admin", Port(6)) })
+	assertPanics(t, "module duplicate", func() {
+		strict.Install(NewModule("ports", Provides(Port(7))))
+	})
+
+	// Modules are checked before anything is installed, so
+	// trying again still fails:
+	ports := NewModule("more-ports", Provides("fine"), ProvidesWith(Port(7), WithName("admin")))
+	for i := 0; i < 2; i++ {
+		if _, ok := strict.TryInstall(ports).(ErrorDuplicateRegistration); !ok {
+			t.Errorf("%d: expected ErrorDuplicateRegistration from TryInstall", i)
+		}
+	}
+	twice := NewModule("twice", Provides(1.5), Includes(NewModule("once", Provides(2.5))))
+	if _, ok := strict.TryInstall(twice).(ErrorDuplicateRegistration); !ok {
+		t.Error("expected modules registering the same thing to be spotted")
+	}
+	if err := strict.CanInject(func(string) {}); err == nil {
+		t.Error("nothing should have been installed")
+	}
+
+	strict.Replace(Port(8))
+	strict.Replace(Port(9), WithName("admin"))
+	strict.Inject(func(p Port, admin Named[Port, AdminName]) {
+		if p != 8 || admin.Value != 9 {
+			t.Errorf("wrong values: %d %d", p, admin.Value)
+		}
+	})
+
+	// Children take on their parent's policy, but can
+	// override what their parent has registered:
+	child := strict.Child()
+	child.Register(Port(10))
+	assertPanics(t, "child duplicate", func() { child.Register(Port(11)) })
+
+	warned := []ErrorDuplicateRegistration{}
+	loose := New()
+	loose.SetDuplicatePolicy(WarnOnDuplicates)
+	loose.SetDuplicateHook(func(err ErrorDuplicateRegistration) {
+		warned = append(warned, err)
+	})
+	loose.Register(Port(1))
+	loose.Register(Port(2))
+	loose.Replace(Port(3))
+	if len(warned) != 1 || warned[0].Ty != reflect.TypeOf(Port(0)) || warned[0].Previous == warned[0].Current {
+		t.Errorf("expected one warning, got: %v", warned)
+	}
+	loose.Inject(func(p Port) {
+		if p != 3 {
+			t.Errorf("wrong value: %d", p)
+		}
+	})
+
+	if WarnOnDuplicates.String() != "WarnOnDuplicates" {
+		t.Error("wrong name for policy")
+	}
+
+}
+
+type AdminName struct{}
+
+func (AdminName) DependsName() string { return "admin" }
+
+// Errors point at where the functions involved were registered.
+func TestRegistrationSites(t *testing.T) {
+
+	type Needy int
+	type Panicky int
+	type Missing int
+
+	ctx := New()
+	ctx.Register("")
+	ctx.Register(func(string, float64, Missing) Needy { return 0 })
+	ctx.Register(1.5)
+	ctx.Register(func() Panicky { panic("oops") })
+	ctx.Register(func(c CycleB) CycleA { return CycleA(c) })
+	ctx.Register(func(c CycleA) CycleB { return CycleB(c) })
+
+	// The position of the argument is given for both the function handed
+	// to TryInject and the function which asked for Missing:
+	err := ctx.TryInject(func(string, Needy) {})
+	if e, ok := err.(ErrorTypeNotRegistered); !ok || e.Pos != 2 || e.RequiredByPos != 3 || !strings.Contains(e.RequiredBy, "depends_test.go:") {
+		t.Errorf("expected the function asking for Missing to be pointed at: %v", err)
+	} else if !strings.Contains(err.Error(), "argument 2 ") || !strings.Contains(err.Error(), "argument 3 of the function registered at "+e.RequiredBy) {
+		t.Errorf("expected both positions to be described: %s", err)
+	}
+	if canErr := ctx.CanInject(func(string, Needy) {}); canErr != err {
+		t.Errorf("expected CanInject to point at the function too: %v", canErr)
+	}
+	var validation ErrorValidation
+	if errors.As(ctx.Validate(), &validation) {
+		if e, ok := validation.Errors[0].(ErrorUnsatisfiedDependency).Err.(ErrorTypeNotRegistered); !ok || e.Pos != 0 || e.RequiredBy != err.(ErrorTypeNotRegistered).RequiredBy || e.RequiredByPos != 3 {
+			t.Errorf("expected Validate to point at the function too: %v", validation.Errors[0])
+		}
+	} else {
+		t.Error("expected Validate to fail")
+	}
+
+	err = ctx.TryInject(func(Panicky) {})
+	if e, ok := err.(ErrorPanicInFunction); !ok || !strings.Contains(e.RegisteredAt, "depends_test.go:") || !strings.Contains(err.Error(), e.RegisteredAt) {
+		t.Errorf("expected the panicking function to be pointed at: %v", err)
+	}
+	if e, _ := ctx.TryInject(func() { panic("here") }).(ErrorPanicInFunction); e.RegisteredAt != "" {
+		t.Error("functions handed to Inject aren't registered")
+	}
+
+	check := func(what string, err error) {
+		e, ok := err.(ErrorCircularInject)
+		if !ok {
+			t.Fatalf("%s: expected ErrorCircularInject, got: %v", what, err)
+		}
+		if len(e.RegisteredAt) != len(e.Chain) || e.RegisteredAt[0] == e.RegisteredAt[1] || e.RegisteredAt[0] != e.RegisteredAt[2] {
+			t.Errorf("%s: wrong sites: %v", what, e.RegisteredAt)
+		}
+		if strings.Count(err.Error(), "depends_test.go:") != 2 {
+			t.Errorf("%s: expected each site to be mentioned once: %s", what, err)
+		}
+	}
+	check("TryInject", ctx.TryInject(func(CycleA) {}))
+	check("CanInject", ctx.CanInject(func(CycleA) {}))
+	cycles := 0
+	if errors.As(ctx.Validate(), &validation) {
+		for _, err := range validation.Errors {
+			if _, ok := err.(ErrorCircularInject); ok {
+				check("Validate", err)
+				cycles++
+			}
+		}
+	}
+	if cycles != 1 {
+		t.Errorf("expected Validate to find one cycle, got %d", cycles)
+	}
+
+	// Modules point at where their items were made:
+	mod := NewModule("mod", Provides(1.5))
+	modCtx := New()
+	modCtx.Install(mod)
+	for _, node := range modCtx.Graph().Nodes {
+		if !strings.Contains(node.RegisteredAt, "depends_test.go:") {
+			t.Errorf("expected the Provides call to be pointed at: %q", node.RegisteredAt)
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	ctx.Graph().WriteDOT(buf)
+	if !strings.Contains(buf.String(), "tooltip=") {
+		t.Error("expected the DOT output to include where things were registered")
+	}
+
+}
@@ -0,0 +1,139 @@
+package depends
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+)
+
+// DuplicatePolicy determines what happens when something is registered on a
+// Context against a type (and name) which already has a registration on that
+// same Context. Registering something on a child Context which is also
+// registered on a parent is never a duplicate; the child's registration is
+// simply used in preference to the parent's.
+type DuplicatePolicy int
+
+const (
+	// AllowDuplicates lets the later registration replace the earlier one.
+	// This is the default.
+	AllowDuplicates DuplicatePolicy = iota
+	// ErrorOnDuplicates panics with an ErrorDuplicateRegistration, leaving
+	// the earlier registration in place (TryInstall returns it instead).
+	// Use Replace to replace it.
+	ErrorOnDuplicates
+	// WarnOnDuplicates lets the later registration replace the earlier
+	// one, and hands an ErrorDuplicateRegistration to the hook set with
+	// SetDuplicateHook (or logs it, if no hook has been set).
+	WarnOnDuplicates
+)
+
+func (p DuplicatePolicy) String() string {
+	switch p {
+	case AllowDuplicates:
+		return "AllowDuplicates"
+	case ErrorOnDuplicates:
+		return "ErrorOnDuplicates"
+	case WarnOnDuplicates:
+		return "WarnOnDuplicates"
+	default:
+		return fmt.Sprintf("DuplicatePolicy(%d)", int(p))
+	}
+}
+
+// SetDuplicatePolicy sets what happens when something is registered on the
+// Context against a type that already has a registration on it. Child Contexts
+// take on the policy of their parent at the time that they are created.
+func (ctx *Context) SetDuplicatePolicy(policy DuplicatePolicy) {
+	ctx.mu.Lock()
+	ctx.duplicates = policy
+	ctx.mu.Unlock()
+}
+
+// SetDuplicateHook sets the function that is handed an ErrorDuplicateRegistration
+// for each duplicate registration made while the Context's policy is
+// WarnOnDuplicates. Child Contexts take on the hook of their parent at the time
+// that they are created.
+func (ctx *Context) SetDuplicateHook(hook func(ErrorDuplicateRegistration)) {
+	ctx.mu.Lock()
+	ctx.onDuplicate = hook
+	ctx.mu.Unlock()
+}
+
+// Replace registers a single dependency into the Context in the same way as
+// RegisterWith, replacing anything already registered against the same type
+// (and name) on the Context whatever its DuplicatePolicy. Use it to make
+// intentional overrides, for example of some value in a test.
+func (ctx *Context) Replace(item interface{}, opts ...Option) {
+	options := newRegisterOptions(opts)
+	options.replace = true
+	ctx.registerOne(item, options)
+}
+
+// putSingle registers val against key, replacing anything already registered
+// against it if the DuplicatePolicy (or opts) allows.
+func (ctx *Context) putSingle(key injectableKey, opts registerOptions, val *injectableValue) {
+	ctx.mu.Lock()
+	policy, hook := ctx.duplicates, ctx.onDuplicate
+	existing, exists := ctx.injectables.get(key)
+	if !exists || opts.replace || policy == AllowDuplicates {
+		ctx.injectables.put(key, val)
+		ctx.mu.Unlock()
+		return
+	}
+
+	err := ErrorDuplicateRegistration{
+		Ty:       key.Ty,
+		Name:     key.Name,
+		Previous: existing.callSite,
+		Current:  val.callSite,
+	}
+	if policy == ErrorOnDuplicates {
+		ctx.mu.Unlock()
+		panic(err)
+	}
+	ctx.injectables.put(key, val)
+	ctx.mu.Unlock()
+
+	if hook != nil {
+		hook(err)
+	} else {
+		log.Printf("depends: %s", err)
+	}
+}
+
+// singleKeys returns the keys that registering item with the options given
+// would register a single value against, so that duplicates can be spotted
+// before anything is registered.
+func singleKeys(item interface{}, opts registerOptions) []injectableKey {
+	ty := reflect.TypeOf(item)
+	if ty == nil || opts.group || opts.decorate || opts.replace {
+		return nil
+	}
+
+	if ty.Kind() == reflect.Func {
+		if ty.NumOut() == 0 {
+			return nil
+		}
+		ty = ty.Out(0)
+		if isOutStruct(ty) && opts.asTy == nil {
+			fields, _ := structFields(ty)
+			keys := []injectableKey{}
+			for _, field := range fields {
+				key := normalizeKey(field.ty)
+				key.Name = opts.name
+				if field.name != "" {
+					key.Name = field.name
+				}
+				keys = append(keys, key)
+			}
+			return keys
+		}
+	}
+	if opts.asTy != nil {
+		ty = opts.asTy
+	}
+
+	key := normalizeKey(ty)
+	key.Name = opts.name
+	return []injectableKey{key}
+}
@@ -13,18 +13,62 @@ func (t ErrorFunctionNotProvided) Error() string {
 	return "Inject/TryInject require a function to be provided"
 }
 
+// ErrorStructNotProvided is returned from TryInjectStruct when
+// the argument passed to it is not a pointer to a struct
+type ErrorStructNotProvided struct{}
+
+func (t ErrorStructNotProvided) Error() string {
+	return "InjectStruct/TryInjectStruct require a pointer to a struct to be provided"
+}
+
 // ErrorTypeNotRegistered is returned from TryInject when the
 // type asked to be injected has not been registered yet
 type ErrorTypeNotRegistered struct {
 	// The type that was not found
 	Ty reflect.Type
+	// The name that the type was asked for with, if any
+	Name string
 	// The position (1 indexed) of the argument in the function
-	// that was handed to TryInject
+	// that was handed to TryInject, or 0 if the type was asked
+	// for directly (for example using Get, or by Validate)
 	Pos int
+	// Where the registered function which asked for the type was
+	// registered, as "file:line", if it was asked for by one
+	RequiredBy string
+	// The position (1 indexed) of the argument in the function
+	// registered at RequiredBy which asked for the type
+	RequiredByPos int
+	// The name of the Module that registered the function at
+	// RequiredBy, if any
+	Module string
 }
 
 func (t ErrorTypeNotRegistered) Error() string {
-	return fmt.Sprintf("Injection of argument %d failed since the type '%s' has not been registered", t.Pos, typeName(t.Ty))
+	what := "the type " + describeKey(t.Ty, t.Name)
+	s := fmt.Sprintf("Injection failed since %s has not been registered", what)
+	if t.Pos != 0 {
+		s = fmt.Sprintf("Injection of argument %d failed since %s has not been registered", t.Pos, what)
+	}
+	switch {
+	case t.RequiredBy != "" && t.RequiredByPos != 0:
+		s += fmt.Sprintf(" (asked for as argument %d of the function %s)", t.RequiredByPos, registeredAt(t.RequiredBy, t.Module))
+	case t.RequiredBy != "":
+		s += fmt.Sprintf(" (asked for by the function %s)", registeredAt(t.RequiredBy, t.Module))
+	}
+	return s
+}
+
+// requiredBy notes that the type was asked for by the argument at pos of the
+// function registered at site (by the module given, if any), unless the error
+// already names some other function (which the one at site depends on) as
+// having asked for it.
+func (t ErrorTypeNotRegistered) requiredBy(site string, module string, pos int) ErrorTypeNotRegistered {
+	if t.RequiredBy == "" && site != "" {
+		t.RequiredBy = site
+		t.RequiredByPos = pos
+		t.Module = module
+	}
+	return t
 }
 
 // ErrorCircularInject is returned from TryInject when there is a
@@ -33,25 +77,343 @@ type ErrorCircularInject struct {
 	// A slice of the types encountered in the order that
 	// their registered functions were called
 	Chain []reflect.Type
+	// Where the function for each type in Chain was registered,
+	// as "file:line", or "" if not known
+	RegisteredAt []string
+	// The name of the Module that registered the function for
+	// each type in Chain, or "" if it wasn't registered by one
+	Modules []string
 }
 
 func (t ErrorCircularInject) Error() string {
-	s := "Injection cycle: "
-	for i, ty := range t.Chain {
-		s += typeName(ty)
-		if i < len(t.Chain)-1 {
-			s += " -> "
+	s := "Injection cycle: " + chainString(t.Chain)
+	seen := map[string]bool{}
+	for i, site := range t.RegisteredAt {
+		if site == "" || seen[site] || i >= len(t.Chain) {
+			continue
 		}
+		seen[site] = true
+		module := ""
+		if i < len(t.Modules) {
+			module = t.Modules[i]
+		}
+		s += fmt.Sprintf("\n  '%s' %s", typeName(t.Chain[i]), registeredAt(site, module))
 	}
 	return s
 }
 
+// ErrorInvalidName is returned from TryInject when a Named type is asked
+// for, but the DependsName method of its name type panics when called on
+// the zero value of that type (for instance because it is a nil pointer).
+type ErrorInvalidName struct {
+	// The Named type that was asked for
+	Ty reflect.Type
+	// What DependsName panicked with
+	Panic interface{}
+}
+
+func (t ErrorInvalidName) Error() string {
+	return fmt.Sprintf("Cannot find the name that '%s' asks for, since calling DependsName on the zero value of its name type panicked: %v", typeName(t.Ty), t.Panic)
+}
+
 // ErrorPanicInFunction is returned if a panic occurs executing
 // a provided function in order to get hold of a requested value.
 type ErrorPanicInFunction struct {
 	Panic interface{}
+	// Where the function that panicked was registered, as
+	// "file:line", if it was a registered function
+	RegisteredAt string
+	// The name of the Module that registered the function, if any
+	Module string
 }
 
 func (t ErrorPanicInFunction) Error() string {
-	return fmt.Sprintf("%s", t.Panic)
+	if t.RegisteredAt == "" {
+		return fmt.Sprintf("%s", t.Panic)
+	}
+	return fmt.Sprintf("%s (in the function %s)", t.Panic, registeredAt(t.RegisteredAt, t.Module))
+}
+
+// ErrorFactoryFailed is returned from TryInject when a function
+// registered to provide some type returned a non-nil error.
+type ErrorFactoryFailed struct {
+	// The type that the failing function was registered to provide
+	Ty reflect.Type
+	// The name of the Module that registered the function, if any
+	Module string
+	// The types that were being created, in order, up to and
+	// including the one that failed
+	Chain []reflect.Type
+	// The error returned from the function
+	Err error
+}
+
+func (t ErrorFactoryFailed) Error() string {
+	return fmt.Sprintf("Failed to create '%s'%s (%s): %s", typeName(t.Ty), inModule(t.Module), chainString(t.Chain), t.Err)
+}
+
+// Unwrap returns the error that the registered function returned.
+func (t ErrorFactoryFailed) Unwrap() error {
+	return t.Err
+}
+
+// ErrorContextClosed is returned from TryInject when the Context
+// being injected from has been closed.
+type ErrorContextClosed struct{}
+
+func (t ErrorContextClosed) Error() string {
+	return "Cannot inject from a Context that has been closed"
+}
+
+// ErrorCleanupFailed describes a cleanup function or Close method
+// which failed when the Context owning its value was closed.
+type ErrorCleanupFailed struct {
+	// The type of the value being cleaned up
+	Ty reflect.Type
+	// The error returned (or an ErrorPanicInFunction if the
+	// cleanup panicked)
+	Err error
+}
+
+func (t ErrorCleanupFailed) Error() string {
+	return fmt.Sprintf("Failed to clean up '%s': %s", typeName(t.Ty), t.Err)
+}
+
+// Unwrap returns the error that the cleanup failed with.
+func (t ErrorCleanupFailed) Unwrap() error {
+	return t.Err
+}
+
+// ErrorCloseFailed is returned from Close when one or more
+// cleanups failed. Every cleanup is attempted regardless.
+type ErrorCloseFailed struct {
+	// The errors encountered, in the order that they happened
+	Errors []error
+}
+
+func (t ErrorCloseFailed) Error() string {
+	s := fmt.Sprintf("%d error(s) closing Context:", len(t.Errors))
+	for _, err := range t.Errors {
+		s += "\n  " + err.Error()
+	}
+	return s
+}
+
+// Unwrap returns the errors encountered.
+func (t ErrorCloseFailed) Unwrap() []error {
+	return t.Errors
+}
+
+// ErrorField describes why a single struct field could
+// not be injected.
+type ErrorField struct {
+	// The name of the field
+	Field string
+	// Why the field could not be injected
+	Err error
+}
+
+func (t ErrorField) Error() string {
+	return fmt.Sprintf("field '%s': %s", t.Field, t.Err)
+}
+
+// Unwrap returns the reason that the field could not be injected.
+func (t ErrorField) Unwrap() error {
+	return t.Err
+}
+
+// ErrorInjectStruct is returned from TryInjectStruct when one or
+// more fields of the struct could not be injected.
+type ErrorInjectStruct struct {
+	// The type of the struct being injected into
+	Ty reflect.Type
+	// Every field that could not be injected, in field order
+	Fields []ErrorField
+}
+
+func (t ErrorInjectStruct) Error() string {
+	s := fmt.Sprintf("Injection into '%s' failed for %d field(s):", typeName(t.Ty), len(t.Fields))
+	for _, field := range t.Fields {
+		s += "\n  " + field.Error()
+	}
+	return s
+}
+
+// Unwrap returns the errors for each field that could not be injected.
+func (t ErrorInjectStruct) Unwrap() []error {
+	errs := make([]error, 0, len(t.Fields))
+	for _, field := range t.Fields {
+		errs = append(errs, field)
+	}
+	return errs
+}
+
+// ErrorUnsatisfiedDependency is reported by Validate when a registered
+// function asks for something which cannot be provided.
+type ErrorUnsatisfiedDependency struct {
+	// The type that the function was registered to provide
+	Ty reflect.Type
+	// The name that the function was registered with, if any
+	Name string
+	// The name of the Module that registered the function, if any
+	Module string
+	// Why the argument could not be provided (normally an
+	// ErrorTypeNotRegistered)
+	Err error
+}
+
+func (t ErrorUnsatisfiedDependency) Error() string {
+	return fmt.Sprintf("The function registered to provide %s%s cannot be called: %s", describeKey(t.Ty, t.Name), inModule(t.Module), t.Err)
+}
+
+// Unwrap returns the reason that the dependency could not be satisfied.
+func (t ErrorUnsatisfiedDependency) Unwrap() error {
+	return t.Err
+}
+
+// ErrorAmbiguousBinding is reported by Validate when a type is
+// registered in more than one way, such that some registrations
+// will never be used.
+type ErrorAmbiguousBinding struct {
+	// The type registered more than once
+	Ty reflect.Type
+	// The name it was registered with, if any
+	Name string
+}
+
+func (t ErrorAmbiguousBinding) Error() string {
+	return fmt.Sprintf("Ambiguous registration: %s is registered directly and as a group, so the group will never be used", describeKey(t.Ty, t.Name))
+}
+
+// ErrorCannotInject is reported by Check for each function
+// that could not have its arguments injected.
+type ErrorCannotInject struct {
+	// The position (0 indexed) of the function in those handed to Check
+	Index int
+	// The type of the function
+	Fn reflect.Type
+	// Why the function could not be injected into, as would be
+	// returned from CanInject
+	Err error
+}
+
+func (t ErrorCannotInject) Error() string {
+	return fmt.Sprintf("Function %d (%s) cannot be injected into: %s", t.Index, typeName(t.Fn), t.Err)
+}
+
+// Unwrap returns the reason that the function could not be injected into.
+func (t ErrorCannotInject) Unwrap() error {
+	return t.Err
+}
+
+// ErrorValidation is returned from Validate and Check, and
+// contains every problem that was found.
+type ErrorValidation struct {
+	Errors []error
+}
+
+func (t ErrorValidation) Error() string {
+	s := fmt.Sprintf("%d problem(s) found validating Context:", len(t.Errors))
+	for _, err := range t.Errors {
+		s += "\n  " + err.Error()
+	}
+	return s
+}
+
+// Unwrap returns the problems found.
+func (t ErrorValidation) Unwrap() []error {
+	return t.Errors
+}
+
+// ErrorBuildFailed is returned from Build if anything
+// could not be created.
+type ErrorBuildFailed struct {
+	// The errors encountered, in the order that they happened
+	Errors []error
+}
+
+func (t ErrorBuildFailed) Error() string {
+	s := fmt.Sprintf("%d error(s) building Context:", len(t.Errors))
+	for _, err := range t.Errors {
+		s += "\n  " + err.Error()
+	}
+	return s
+}
+
+// Unwrap returns the errors encountered.
+func (t ErrorBuildFailed) Unwrap() []error {
+	return t.Errors
+}
+
+// ErrorCanceled is returned from TryInjectContext (or Build) when the
+// context.Context provided is done before injection has finished.
+type ErrorCanceled struct {
+	// The types that were being created, in order, when the
+	// cancellation was noticed
+	Chain []reflect.Type
+	// The error from the context.Context
+	Err error
+}
+
+func (t ErrorCanceled) Error() string {
+	if len(t.Chain) == 0 {
+		return fmt.Sprintf("Injection cancelled: %s", t.Err)
+	}
+	return fmt.Sprintf("Injection cancelled while creating %s: %s", chainString(t.Chain), t.Err)
+}
+
+// Unwrap returns the error from the context.Context.
+func (t ErrorCanceled) Unwrap() error {
+	return t.Err
+}
+
+// ErrorArgumentNotUsed is returned from TryInjectWith when an argument
+// handed to it cannot be handed on to the function provided.
+type ErrorArgumentNotUsed struct {
+	// The type of the argument, or nil if it was nil
+	Ty reflect.Type
+	// The position (1 indexed) that the argument was given
+	// for using At, or 0 if it was matched by type
+	Pos int
+}
+
+func (t ErrorArgumentNotUsed) Error() string {
+	what := "nil"
+	if t.Ty != nil {
+		what = "'" + typeName(t.Ty) + "'"
+	}
+	if t.Pos == 0 {
+		return fmt.Sprintf("Argument of type %s does not match any remaining argument of the function", what)
+	}
+	return fmt.Sprintf("Argument of type %s cannot be used for argument %d of the function", what, t.Pos)
+}
+
+// ErrorDuplicateModule is returned from TryInstall when two different
+// modules with the same name would be installed into a Context.
+type ErrorDuplicateModule struct {
+	// The name shared by the modules
+	Name string
+}
+
+func (t ErrorDuplicateModule) Error() string {
+	return fmt.Sprintf("A different module named '%s' has already been installed", t.Name)
+}
+
+// ErrorDuplicateRegistration describes something being registered on a
+// Context against a type (and name) which already has a registration on
+// that Context. See DuplicatePolicy.
+type ErrorDuplicateRegistration struct {
+	// The type registered against
+	Ty reflect.Type
+	// The name registered against, if any
+	Name string
+	// Where the earlier and later registrations were made, as
+	// "file:line"
+	Previous string
+	Current  string
+}
+
+func (t ErrorDuplicateRegistration) Error() string {
+	return fmt.Sprintf("%s was registered at %s, and then registered again at %s (use Replace to replace it intentionally)",
+		describeKey(t.Ty, t.Name), t.Previous, t.Current)
 }
@@ -2,8 +2,10 @@ package depends
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"path/filepath"
 	"strings"
 )
 
@@ -149,25 +151,21 @@ func ExampleContext_Inject() {
 
 func ExampleContext_Inject_interfaces() {
 
-	// We need to surround interfaces in
-	// concrete types:
-	type R struct{ I io.Reader }
-	type W struct{ I io.Writer }
-
 	ctx := New()
 
 	out := bytes.Buffer{}
 
-	// Satisfy our interfaces by instantiating and
-	// registering some concrete types:
-	ctx.Register(R{strings.NewReader("Read from this")})
-	ctx.Register(W{&out})
+	// Satisfy our interfaces by registering some concrete
+	// types against them. We pass a nil pointer to the
+	// interface type we'd like to register against:
+	ctx.RegisterAs(strings.NewReader("Read from this"), (*io.Reader)(nil))
+	ctx.RegisterAs(&out, (*io.Writer)(nil))
 
 	// Make use of the interfaces. To test the copyOut
 	// function, we could instead inject into it using a
 	// context that mocks out the interfaces.
-	copyOut := func(r R, w W) {
-		io.Copy(w.I, r.I)
+	copyOut := func(r io.Reader, w io.Writer) {
+		io.Copy(w, r)
 	}
 
 	ctx.Inject(copyOut)
@@ -176,6 +174,24 @@ func ExampleContext_Inject_interfaces() {
 	// Output: Read from this
 }
 
+func ExampleContext_RegisterAs() {
+
+	ctx := New()
+
+	// Functions returning a concrete type can be
+	// registered against an interface as well:
+	ctx.RegisterAs(func() *strings.Reader {
+		return strings.NewReader("hello")
+	}, (*io.Reader)(nil))
+
+	ctx.Inject(func(r io.Reader) {
+		b, _ := io.ReadAll(r)
+		fmt.Println(string(b))
+	})
+
+	// Output: hello
+}
+
 // If we register a function that returns some type, rather than just a type,
 // it will be called the first time that we try to inject that type into something.
 // The function can itself ask for things to be injected into it.
@@ -241,7 +257,343 @@ func ExampleContext_TryInject() {
 		fmt.Println("Registration function loop")
 	case ErrorTypeNotRegistered:
 		fmt.Println("Type not registered")
+	case ErrorFactoryFailed:
+		fmt.Println("Registration function returned an error")
 	}
 
 	// Output: Type not registered
 }
+
+func ExampleContext_TryCall() {
+
+	type Addr string
+
+	ctx := New()
+	ctx.Register(Addr("localhost:8080"))
+
+	// Values returned from the function are handed back,
+	// and a trailing error becomes the error from TryCall:
+	results, err := ctx.TryCall(func(a Addr) (string, error) {
+		return "listening on " + string(a), nil
+	})
+
+	fmt.Println(results[0], err)
+
+	// Output: listening on localhost:8080 <nil>
+}
+
+func ExampleContext_InjectWith() {
+
+	type Greeting string
+	type UserName string
+
+	ctx := New()
+	ctx.Register(Greeting("Hello"))
+
+	// Values handed to InjectWith are used alongside
+	// those that are registered:
+	ctx.InjectWith(func(g Greeting, name UserName) {
+		fmt.Printf("%s, %s!\n", g, name)
+	}, UserName("Alice"))
+
+	// Output: Hello, Alice!
+}
+
+func ExampleGet() {
+
+	type Foo int
+	type Bar struct{ Value int }
+
+	ctx := New()
+	ctx.Register(Foo(100))
+
+	// Provide registers something against a specific
+	// type, which is particularly useful for interfaces:
+	Provide[io.Reader](ctx, func() *strings.Reader {
+		return strings.NewReader("hello")
+	})
+
+	// Get and MustGet pull values out of the Context
+	// without needing a function to inject into:
+	foo := MustGet[Foo](ctx)
+	fmt.Println(foo)
+
+	r := MustGet[io.Reader](ctx)
+	b, _ := io.ReadAll(r)
+	fmt.Println(string(b))
+
+	// Get returns an error rather than panicking if
+	// the value could not be obtained:
+	_, err := Get[Bar](ctx)
+	fmt.Println(err)
+
+	// Output:
+	// 100
+	// hello
+	// Injection failed since the type 'Bar' has not been registered
+}
+
+// Types used to provide names to Named must be declared
+// at the top level, since they need a method:
+type PrimaryName struct{}
+
+func (PrimaryName) DependsName() string { return "primary" }
+
+type ReplicaName struct{}
+
+func (ReplicaName) DependsName() string { return "replica" }
+
+func ExampleNamed() {
+
+	type DB struct{ Host string }
+
+	ctx := New()
+
+	// Register two values of the same type by name:
+	ctx.RegisterNamed("primary", &DB{"primary-host"})
+	ctx.RegisterNamed("replica", &DB{"replica-host"})
+
+	// Ask for each of them with Named. The second type
+	// parameter is used to provide the name:
+	ctx.Inject(func(p Named[*DB, PrimaryName], r Named[*DB, ReplicaName]) {
+		fmt.Println(p.Value.Host)
+		fmt.Println(r.Value.Host)
+	})
+
+	// Or use GetNamed to ask for them by name directly:
+	fmt.Println(MustGetNamed[*DB](ctx, "replica").Host)
+
+	// Output:
+	// primary-host
+	// replica-host
+	// replica-host
+}
+
+func ExampleOptional() {
+
+	type Tracer struct{ Name string }
+
+	ctx := New()
+
+	// Nothing has been registered for *Tracer, so
+	// OK is false rather than injection failing:
+	ctx.Inject(func(tracer Optional[*Tracer]) {
+		fmt.Println("tracing:", tracer.OK)
+	})
+
+	ctx.Register(&Tracer{"stdout"})
+	ctx.Inject(func(tracer Optional[*Tracer]) {
+		fmt.Println("tracing:", tracer.OK, tracer.Value.Name)
+	})
+
+	// Output:
+	// tracing: false
+	// tracing: true stdout
+}
+
+func ExampleProvider() {
+
+	type Report string
+
+	ctx := New()
+	ctx.Register(func() Report {
+		fmt.Println("building report")
+		return "quarterly report"
+	})
+
+	// The report is only built if Get is called:
+	ctx.Inject(func(report Provider[Report]) {
+		fmt.Println("starting")
+		fmt.Println(report.MustGet())
+	})
+
+	// Output:
+	// starting
+	// building report
+	// quarterly report
+}
+
+func ExampleContext_Decorate() {
+
+	type Greeting struct{ Text string }
+
+	ctx := New()
+	ctx.Register(Greeting{"Hello"})
+
+	// A child Context can wrap the Greeting without replacing it:
+	child := ctx.Child()
+	child.Decorate(func(g Greeting) Greeting {
+		return Greeting{g.Text + "!"}
+	})
+
+	ctx.Inject(func(g Greeting) { fmt.Println(g.Text) })
+	child.Inject(func(g Greeting) { fmt.Println(g.Text) })
+
+	// Output:
+	// Hello
+	// Hello!
+}
+
+func ExampleModule() {
+
+	type Logger struct{ Prefix string }
+	type DB struct{ Log *Logger }
+
+	logging := NewModule("logging",
+		Provides(&Logger{"[db] "}),
+	)
+	database := NewModule("database",
+		Includes(logging),
+		Provides(func(log *Logger) *DB { return &DB{log} }),
+	)
+
+	ctx := New()
+	ctx.Install(database)
+
+	ctx.Inject(func(db *DB) {
+		fmt.Println(db.Log.Prefix + "connected")
+	})
+
+	// Output: [db] connected
+}
+
+func ExampleContext_RegisterGroup() {
+
+	type HealthCheck struct{ Name string }
+
+	ctx := New()
+	ctx.RegisterGroup(HealthCheck{"database"})
+	ctx.RegisterGroup(func() HealthCheck { return HealthCheck{"cache"} })
+
+	childCtx := ctx.Child()
+	childCtx.RegisterGroup(HealthCheck{"queue"})
+
+	// Asking for a slice provides every contribution to the
+	// group, starting with those registered on the parent:
+	childCtx.Inject(func(checks []HealthCheck) {
+		for _, check := range checks {
+			fmt.Println(check.Name)
+		}
+	})
+
+	// Output:
+	// database
+	// cache
+	// queue
+}
+
+func ExampleContext_RegisterWith() {
+
+	type RequestID int
+	type Session struct{ ID int }
+
+	ctx := New()
+
+	// Transient functions are called every time their
+	// type is asked for:
+	nextID := 0
+	ctx.RegisterWith(func() RequestID {
+		nextID++
+		return RequestID(nextID)
+	}, WithLifetime(Transient))
+
+	// Scoped functions are called once per Context:
+	ctx.RegisterWith(func(id RequestID) *Session {
+		return &Session{int(id)}
+	}, WithLifetime(Scoped))
+
+	ctx.Inject(func(a RequestID, b RequestID) {
+		fmt.Println(a, b)
+	})
+
+	ctx.Inject(func(s1 *Session, s2 *Session) {
+		fmt.Println(s1.ID, s2.ID)
+	})
+	ctx.Child().Inject(func(s *Session) {
+		fmt.Println(s.ID)
+	})
+
+	// Output:
+	// 1 2
+	// 3 3
+	// 4
+}
+
+func ExampleContext_Close() {
+
+	type Pool struct{}
+	type Client struct{}
+
+	ctx := New()
+
+	// Functions can return a cleanup function alongside
+	// the value they create (and optionally an error):
+	ctx.Register(func() (*Pool, func(), error) {
+		fmt.Println("opening pool")
+		return &Pool{}, func() { fmt.Println("closing pool") }, nil
+	})
+	ctx.Register(func(p *Pool) (*Client, func()) {
+		fmt.Println("creating client")
+		return &Client{}, func() { fmt.Println("closing client") }
+	})
+
+	ctx.Inject(func(c *Client) {})
+
+	// Closing the Context cleans things up in the reverse
+	// order to that which they were created in:
+	ctx.Close()
+
+	// Output:
+	// opening pool
+	// creating client
+	// closing client
+	// closing pool
+}
+
+func ExampleContext_InjectStruct() {
+
+	type Logger struct{ Prefix string }
+	type DB struct{ Host string }
+
+	// Exported fields are injected, and can be
+	// tweaked using a "depends" tag:
+	type Handler struct {
+		Logger  *Logger
+		Replica *DB `depends:"name=replica"`
+		Count   int `depends:"-"`
+	}
+
+	ctx := New()
+	ctx.Register(&Logger{"[handler]"})
+	ctx.RegisterNamed("replica", &DB{"replica-host"})
+
+	h := Handler{}
+	ctx.InjectStruct(&h)
+	fmt.Println(h.Logger.Prefix, h.Replica.Host)
+
+	// Output: [handler] replica-host
+}
+
+func ExampleContext_Validate() {
+
+	type Config struct{}
+	type DB struct{}
+	type Server struct{}
+
+	ctx := New()
+	ctx.Register(func(c Config) DB { return DB{} })
+	ctx.Register(func(db DB) Server { return Server{} })
+
+	// Nothing is called, but we find out that Config
+	// is missing before anything asks for a Server, and
+	// where the function that needs it was registered:
+	err := ctx.Validate()
+	var missing ErrorTypeNotRegistered
+	if errors.As(err, &missing) {
+		fmt.Printf("'%s' is missing for argument %d of the function registered at %s\n",
+			missing.Ty.Name(), missing.RequiredByPos, filepath.Base(missing.RequiredBy))
+	}
+
+	// Output:
+	// 'Config' is missing for argument 1 of the function registered at examples_test.go:584
+}
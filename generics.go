@@ -0,0 +1,64 @@
+package depends
+
+import (
+	"reflect"
+)
+
+// Get returns the value registered against the type T in the Context provided,
+// or an error describing why it could not be obtained. Asking for a value this
+// way behaves exactly like asking for it as an argument to TryInject, so T can
+// be a pointer or an interface type as well as a plain type.
+func Get[T any](ctx *Context) (T, error) {
+	return valueAs[T](ctx.getInjectable(newResolution(nil), typeOf[T]()))
+}
+
+// MustGet returns the value registered against the type T in the Context
+// provided. If anything goes wrong, it will panic.
+func MustGet[T any](ctx *Context) T {
+	out, err := Get[T](ctx)
+	if err != nil {
+		panic(err.Error())
+	}
+	return out
+}
+
+// GetNamed returns the value of type T registered against the given name
+// in the Context provided, or an error describing why it could not be obtained.
+func GetNamed[T any](ctx *Context, name string) (T, error) {
+	return valueAs[T](ctx.getNamedInjectable(newResolution(nil), typeOf[T](), name))
+}
+
+// MustGetNamed returns the value of type T registered against the given name
+// in the Context provided. If anything goes wrong, it will panic.
+func MustGetNamed[T any](ctx *Context, name string) T {
+	out, err := GetNamed[T](ctx, name)
+	if err != nil {
+		panic(err.Error())
+	}
+	return out
+}
+
+// Provide registers a dependency into the Context against the type T. As
+// with Register, the item can be a value or a function that returns a value
+// (and optionally an error). In either case the value must be assignable to
+// T, which makes this a convenient way to register things against interfaces.
+// Options can be provided to tweak the registration, as with RegisterWith.
+func Provide[T any](ctx *Context, item interface{}, opts ...Option) {
+	options := newRegisterOptions(opts)
+	options.asTy = typeOf[T]()
+	ctx.registerOne(item, options)
+}
+
+func typeOf[T any]() reflect.Type {
+	return reflect.TypeOf((*T)(nil)).Elem()
+}
+
+// valueAs converts the result of a lookup into a T.
+func valueAs[T any](val reflect.Value, err error) (T, error) {
+	var out T
+	if err != nil {
+		return out, err
+	}
+	reflect.ValueOf(&out).Elem().Set(val)
+	return out, nil
+}
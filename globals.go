@@ -1,30 +1,148 @@
 package depends
 
+import (
+	"context"
+)
+
 // A global context is provided for convenience:
-var context = New()
+var globalContext = New()
 
 // Child creates a child context. This Context can use anything registered
 // with the global Context, but the inverse is not true: anything registered on it
 // will not be visible to the global context.
 func Child() *Context {
-	return context.Child()
+	return globalContext.Child()
 }
 
 // Register registers a dependency into a global Context to later be used
 func Register(items ...interface{}) {
-	context.Register(items...)
+	globalContext.Register(items...)
+}
+
+// RegisterNamed registers dependencies into a global Context against the name given
+func RegisterNamed(name string, items ...interface{}) {
+	globalContext.RegisterNamed(name, items...)
+}
+
+// RegisterGroup registers dependencies into a global Context as contributions to a group
+func RegisterGroup(items ...interface{}) {
+	globalContext.RegisterGroup(items...)
+}
+
+// RegisterAs registers a dependency into a global Context against the interface
+// type pointed to by iface, for example (*io.Reader)(nil).
+func RegisterAs(item interface{}, iface interface{}) {
+	globalContext.RegisterAs(item, iface)
+}
+
+// RegisterWith registers a single dependency into a global Context using the options given
+func RegisterWith(item interface{}, opts ...Option) {
+	globalContext.RegisterWith(item, opts...)
+}
+
+// Replace registers a single dependency into the global context, replacing
+// anything already registered against the same type whatever the global
+// context's DuplicatePolicy.
+func Replace(item interface{}, opts ...Option) {
+	globalContext.Replace(item, opts...)
+}
+
+// SetDuplicatePolicy sets what happens when something is registered on the
+// global context against a type that already has a registration on it.
+func SetDuplicatePolicy(policy DuplicatePolicy) {
+	globalContext.SetDuplicatePolicy(policy)
+}
+
+// SetDuplicateHook sets the function that is handed duplicate registrations
+// made on the global context while its policy is WarnOnDuplicates.
+func SetDuplicateHook(hook func(ErrorDuplicateRegistration)) {
+	globalContext.SetDuplicateHook(hook)
+}
+
+// Decorate wraps whatever is registered against some type T in the global
+// context, so that asking for T provides the value returned from the decorator
+// instead. See Context.Decorate for more.
+func Decorate(fn interface{}) {
+	globalContext.Decorate(fn)
+}
+
+// Install makes the registrations of each of the modules given, and any that
+// they include, on the global context. See Context.Install for more.
+func Install(mods ...*Module) {
+	globalContext.Install(mods...)
+}
+
+// TryInstall installs the modules given on the global context in the same way
+// as Install, but returns an error rather than panicking if something goes wrong.
+func TryInstall(mods ...*Module) error {
+	return globalContext.TryInstall(mods...)
 }
 
 // TryInject injects the dependencies asked for from the global context into the
 // function provided. If anything goes wrong, the function provided is not called
 // and instead an error is returned describing the issue.
 func TryInject(fn interface{}) error {
-	return context.TryInject(fn)
+	return globalContext.TryInject(fn)
+}
+
+// TryInjectContext injects the dependencies asked for from the global context into the
+// function provided, in the same way as TryInject, but allows the process to be
+// cancelled using c.
+func TryInjectContext(c context.Context, fn interface{}) error {
+	return globalContext.TryInjectContext(c, fn)
+}
+
+// Call injects the dependencies asked for from the global context into the function
+// provided, and returns the values that it returns. If the function's last return
+// value is an error, it is left out of the values returned, and Call panics if it is
+// not nil.
+func Call(fn interface{}) []interface{} {
+	return globalContext.Call(fn)
+}
+
+// TryCall injects the dependencies asked for from the global context into the function
+// provided, and returns the values that it returns. If the function's last return value
+// is an error, it is left out of the values returned and handed back as the error instead.
+func TryCall(fn interface{}) ([]interface{}, error) {
+	return globalContext.TryCall(fn)
+}
+
+// TryCallContext is like TryCall, but allows the process to be cancelled using c.
+func TryCallContext(c context.Context, fn interface{}) ([]interface{}, error) {
+	return globalContext.TryCallContext(c, fn)
 }
 
 // Inject injects the dependencies asked for into the function provided. If anything
 // goes wrong, it will panic. It's expected that this will be used in favour of TryInject
 // in most cases, since failure to inject something is normally a sign of programmer error.
 func Inject(fn interface{}) {
-	context.Inject(fn)
+	globalContext.Inject(fn)
+}
+
+// InjectWith injects the dependencies asked for from the global context into the
+// function provided, using the arguments given in preference to anything registered.
+// If anything goes wrong, it will panic.
+func InjectWith(fn interface{}, args ...interface{}) {
+	globalContext.InjectWith(fn, args...)
+}
+
+// TryInjectWith injects the dependencies asked for from the global context into the
+// function provided, using the arguments given in preference to anything registered.
+// If anything goes wrong, the function provided is not called and instead an error is
+// returned describing the issue.
+func TryInjectWith(fn interface{}, args ...interface{}) error {
+	return globalContext.TryInjectWith(fn, args...)
+}
+
+// InjectStruct fills in the exported fields of the struct pointed to by ptr with
+// values from the global Context. If anything goes wrong, it will panic.
+func InjectStruct(ptr interface{}) {
+	globalContext.InjectStruct(ptr)
+}
+
+// TryInjectStruct fills in the exported fields of the struct pointed to by ptr with
+// values from the global Context. If anything goes wrong, the struct is left untouched
+// and an error is returned describing the issue.
+func TryInjectStruct(ptr interface{}) error {
+	return globalContext.TryInjectStruct(ptr)
 }
@@ -0,0 +1,248 @@
+package depends
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Graph describes everything registered on a Context and its parents, and how
+// registered functions depend on one another. It's returned from Context.Graph,
+// and can be written out as JSON or in Graphviz DOT format.
+type Graph struct {
+	Nodes []GraphNode `json:"nodes"`
+	Edges []GraphEdge `json:"edges"`
+}
+
+// GraphNode is a single registration, or a type that was asked for by some
+// registered function but has not been registered.
+type GraphNode struct {
+	// A unique identifier for the node, used by GraphEdges.
+	ID string `json:"id"`
+	// The registered type, and the name it was registered with, if any.
+	Type string `json:"type"`
+	Name string `json:"name,omitempty"`
+	// True if this is a contribution to a group.
+	Group bool `json:"group,omitempty"`
+	// True if a function was registered, rather than a value.
+	Function bool `json:"function,omitempty"`
+	// True if the function was registered with Decorate, and
+	// so wraps the value provided by the node it depends on
+	// at position 1.
+	Decorator bool `json:"decorator,omitempty"`
+	// The Lifetime of a registered function.
+	Lifetime string `json:"lifetime,omitempty"`
+	// The name of the Module that made the registration, if any.
+	Module string `json:"module,omitempty"`
+	// Where the registration was made, as "file:line".
+	RegisteredAt string `json:"registeredAt,omitempty"`
+	// Which Context the registration was made on: 0 for the
+	// Context that Graph was called on, 1 for its parent, and
+	// so on.
+	Depth int `json:"depth"`
+	// True if the value has been created (and so any function
+	// registered to create it has been called). Values which
+	// were registered directly are always initialised.
+	Initialised bool `json:"initialised"`
+	// True if nothing is registered for this type; it's been
+	// asked for by some function but will not be found.
+	Missing bool `json:"missing,omitempty"`
+}
+
+// GraphEdge notes that the registered function for one node asks for the
+// value provided by another.
+type GraphEdge struct {
+	// The ID of the node whose function asks for the value.
+	From string `json:"from"`
+	// The ID of the node providing the value.
+	To string `json:"to"`
+	// The position (1 indexed) of the argument asking for the value.
+	Pos int `json:"pos"`
+	// True if the value is asked for using a Provider (or similar),
+	// and so is only created when it's actually needed.
+	Lazy bool `json:"lazy,omitempty"`
+}
+
+// Graph returns a description of everything that can be injected from the
+// Context, including registrations on parent Contexts, and the dependencies
+// between registered functions. Nothing is called in order to build it.
+func (ctx *Context) Graph() Graph {
+	g := Graph{Nodes: []GraphNode{}, Edges: []GraphEdge{}}
+	ids := map[*injectableValue]string{}
+	missing := map[injectableKey]string{}
+
+	lineage := ctx.lineage()
+	bindings := []binding{}
+	addNode := func(depth int, e entry, group bool, decorator bool) {
+		b := ctx.bindingOf(e.key, e.val)
+		id := fmt.Sprintf("n%d", len(g.Nodes)+1)
+		ids[e.val] = id
+		bindings = append(bindings, b)
+		g.Nodes = append(g.Nodes, GraphNode{
+			ID:           id,
+			Type:         e.key.Ty.String(),
+			Name:         e.key.Name,
+			Group:        group,
+			Function:     e.val.itemMaker != nil,
+			Decorator:    decorator,
+			Lifetime:     lifetimeOf(e.val),
+			Module:       e.val.module,
+			RegisteredAt: e.val.callSite,
+			Depth:        depth,
+			Initialised:  b.constructed(),
+		})
+	}
+	for i, c := range lineage {
+		depth := len(lineage) - 1 - i
+		for _, e := range c.injectables.all() {
+			addNode(depth, e, false, false)
+		}
+		for _, e := range c.groups.all() {
+			addNode(depth, e, true, false)
+		}
+		for _, e := range c.decorators.all() {
+			addNode(depth, e, false, true)
+		}
+	}
+
+	for _, b := range bindings {
+		for i, param := range b.arg.params {
+			deps, err := b.paramBindings(i, param)
+			if err != nil {
+				key, _ := keyFor(param)
+				if e, ok := err.(ErrorTypeNotRegistered); ok {
+					key = injectableKey{Ty: e.Ty, Name: e.Name}
+				}
+				id, ok := missing[key]
+				if !ok {
+					id = fmt.Sprintf("n%d", len(g.Nodes)+1)
+					missing[key] = id
+					g.Nodes = append(g.Nodes, GraphNode{
+						ID:      id,
+						Type:    key.Ty.String(),
+						Name:    key.Name,
+						Missing: true,
+					})
+				}
+				g.Edges = append(g.Edges, GraphEdge{From: ids[b.arg], To: id, Pos: i + 1})
+				continue
+			}
+			for _, dep := range deps {
+				g.Edges = append(g.Edges, GraphEdge{From: ids[b.arg], To: ids[dep.arg], Pos: i + 1, Lazy: dep.lazy})
+			}
+		}
+	}
+
+	return g
+}
+
+// WriteJSON writes the Graph to w as JSON.
+func (g Graph) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(g)
+}
+
+// WriteDOT writes the Graph to w in Graphviz DOT format. Registrations are
+// grouped by the Context they were made on, with where each was made as its
+// tooltip. Values which have not been created yet are drawn dashed, missing
+// types are drawn in red, and dependencies which are asked for lazily are
+// drawn dotted.
+func (g Graph) WriteDOT(w io.Writer) error {
+	b := &strings.Builder{}
+	b.WriteString("digraph depends {\n")
+	b.WriteString("  node [shape=box];\n")
+
+	// Nodes are grouped into a cluster per Context:
+	byDepth := map[int][]GraphNode{}
+	maxDepth := 0
+	for _, node := range g.Nodes {
+		if node.Missing {
+			continue
+		}
+		byDepth[node.Depth] = append(byDepth[node.Depth], node)
+		if node.Depth > maxDepth {
+			maxDepth = node.Depth
+		}
+	}
+	for depth := maxDepth; depth >= 0; depth-- {
+		nodes, ok := byDepth[depth]
+		if !ok {
+			continue
+		}
+		label := "Context"
+		if depth > 0 {
+			label = fmt.Sprintf("Parent %d", depth)
+		}
+		fmt.Fprintf(b, "  subgraph cluster_%d {\n", depth)
+		fmt.Fprintf(b, "    label=%s;\n", dotQuote(label))
+		for _, node := range nodes {
+			fmt.Fprintf(b, "    %s;\n", dotNode(node))
+		}
+		b.WriteString("  }\n")
+	}
+	for _, node := range g.Nodes {
+		if node.Missing {
+			fmt.Fprintf(b, "  %s;\n", dotNode(node))
+		}
+	}
+
+	for _, edge := range g.Edges {
+		style := ""
+		if edge.Lazy {
+			style = ", style=dotted"
+		}
+		fmt.Fprintf(b, "  %s -> %s [label=%s%s];\n", edge.From, edge.To, dotQuote(fmt.Sprint(edge.Pos)), style)
+	}
+
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func dotNode(node GraphNode) string {
+	lines := []string{node.Type}
+	if node.Name != "" {
+		lines = append(lines, "name: "+node.Name)
+	}
+	if node.Group {
+		lines = append(lines, "group")
+	}
+	if node.Decorator {
+		lines = append(lines, "decorator")
+	}
+	if node.Lifetime != "" {
+		lines = append(lines, strings.ToLower(node.Lifetime))
+	}
+	if node.Module != "" {
+		lines = append(lines, "module: "+node.Module)
+	}
+
+	attrs := []string{"label=" + dotQuote(strings.Join(lines, "\n"))}
+	if node.Missing {
+		attrs = append(attrs, "color=red", "fontcolor=red")
+	} else if !node.Initialised {
+		attrs = append(attrs, "style=dashed")
+	}
+	if node.RegisteredAt != "" {
+		attrs = append(attrs, "tooltip="+dotQuote(node.RegisteredAt))
+	}
+	return fmt.Sprintf("%s [%s]", node.ID, strings.Join(attrs, ", "))
+}
+
+func dotQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	return `"` + s + `"`
+}
+
+// lifetimeOf returns the Lifetime of a registered function as a string,
+// or nothing for values registered directly.
+func lifetimeOf(arg *injectableValue) string {
+	if arg.itemMaker == nil {
+		return ""
+	}
+	return arg.lifetime.String()
+}
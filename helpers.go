@@ -2,9 +2,35 @@ package depends
 
 import (
 	"fmt"
+	"path/filepath"
 	"reflect"
+	"runtime"
+	"strings"
 )
 
+// packageDir is the directory holding the source of this package,
+// so that we can tell which stack frames belong to it.
+var packageDir = func() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Dir(file)
+}()
+
+// callSite returns the file and line of the first caller from outside
+// of this package (counting its tests as outside), as "file:line".
+func callSite() string {
+	pcs := make([]uintptr, 32)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs)])
+	for {
+		frame, more := frames.Next()
+		if filepath.Dir(frame.File) != packageDir || strings.HasSuffix(frame.File, "_test.go") {
+			return fmt.Sprintf("%s:%d", frame.File, frame.Line)
+		}
+		if !more {
+			return ""
+		}
+	}
+}
+
 func typeName(ty reflect.Type) string {
 	s := ""
 	for {
@@ -15,28 +41,67 @@ func typeName(ty reflect.Type) string {
 			break
 		}
 	}
-	s += ty.Name()
+	switch {
+	case ty.Name() != "":
+		s += ty.Name()
+	case ty.Kind() == reflect.Slice:
+		s += "[]" + typeName(ty.Elem())
+	default:
+		s += ty.String()
+	}
 	return s
 }
 
-func appendType(s []reflect.Type, ty reflect.Type) []reflect.Type {
-	out := make([]reflect.Type, 0, len(s)+1)
-	for _, item := range s {
-		out = append(out, item)
+// funcParams returns the argument types of the function type given.
+func funcParams(fnTy reflect.Type) []reflect.Type {
+	params := make([]reflect.Type, fnTy.NumIn())
+	for i := range params {
+		params[i] = fnTy.In(i)
 	}
-	out = append(out, ty)
-	return out
+	return params
+}
+
+// inModule notes the Module that a registration was made by, if
+// any, for use in error messages.
+func inModule(module string) string {
+	if module == "" {
+		return ""
+	}
+	return fmt.Sprintf(" (from module '%s')", module)
 }
 
-func typeExistsInSlice(s []reflect.Type, ty reflect.Type) bool {
-	for _, sTy := range s {
-		if ty == sTy {
-			return true
+// registeredAt describes where a registration was made, and by which
+// Module if any, for use in error messages.
+func registeredAt(site string, module string) string {
+	if module == "" {
+		return "registered at " + site
+	}
+	return fmt.Sprintf("registered at %s by module '%s'", site, module)
+}
+
+// describeKey describes a type and the name it was registered
+// against, if any, for use in error messages.
+func describeKey(ty reflect.Type, name string) string {
+	if name == "" {
+		return fmt.Sprintf("'%s'", typeName(ty))
+	}
+	return fmt.Sprintf("'%s' with the name '%s'", typeName(ty), name)
+}
+
+func chainString(chain []reflect.Type) string {
+	s := ""
+	for i, ty := range chain {
+		s += typeName(ty)
+		if i < len(chain)-1 {
+			s += " -> "
 		}
 	}
-	return false
+	return s
 }
 
+// normalizeKey strips any pointers from the type given, so that *T and T
+// share the same key. Interface types are left alone, and so are keyed on
+// the interface itself rather than whatever concrete type satisfies it.
 func normalizeKey(ty reflect.Type) injectableKey {
 	for {
 		if ty.Kind() == reflect.Ptr {
@@ -45,7 +110,19 @@ func normalizeKey(ty reflect.Type) injectableKey {
 			break
 		}
 	}
-	return injectableKey{ty}
+	return injectableKey{Ty: ty}
+}
+
+// convertValue returns a copy of val with the type ty, which val must be
+// assignable to. This is used to hang on to interface types, which would
+// otherwise be lost in favour of the concrete type of the value.
+func convertValue(val reflect.Value, ty reflect.Type) reflect.Value {
+	if val.Type() == ty {
+		return val
+	}
+	out := reflect.New(ty).Elem()
+	out.Set(val)
+	return out
 }
 
 func normalizeValue(val reflect.Value) reflect.Value {
@@ -73,6 +150,10 @@ func normalizeValue(val reflect.Value) reflect.Value {
 
 }
 
+// denormalizeValue takes a normalized value and adds or removes pointers until it
+// matches the target type. Values registered against an interface are stored as a
+// pointer to that interface, and so are dereferenced only as far as the interface
+// itself, never to the concrete value inside it.
 func denormalizeValue(val reflect.Value, targetType reflect.Type) (reflect.Value, error) {
 
 	// if match, return quick:
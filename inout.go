@@ -0,0 +1,246 @@
+package depends
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// In is embedded in a struct to make it a parameter object. When a function
+// (registered or handed to Inject) asks for a struct embedding In, each of its
+// exported fields is injected, as though the function had asked for them as
+// separate arguments. This keeps functions with many dependencies readable:
+//
+//	type ServerParams struct {
+//		depends.In
+//		DB      *DB
+//		Replica *DB    `depends:"name=replica"`
+//		Cache   *Cache `depends:"optional"`
+//	}
+//
+// Fields can be tweaked with a `depends` tag in the same way as with
+// InjectStruct. Fields tagged `optional` are left as their zero value if
+// nothing has been registered for them.
+type In struct{}
+
+// Out is embedded in a struct to make it a result object. When a function
+// returning a struct embedding Out is registered, each of the struct's exported
+// fields is registered as a separate type, provided by calling the function.
+// The function is only called once for all of them (according to its Lifetime):
+//
+//	type Connections struct {
+//		depends.Out
+//		DB      *DB
+//		Replica *DB `depends:"name=replica"`
+//	}
+//
+// Fields tagged `depends:"name=replica"` are registered against that name, and
+// fields tagged `depends:"-"` are not registered. The struct itself is not
+// registered.
+type Out struct{}
+
+var inType = reflect.TypeOf(In{})
+var outType = reflect.TypeOf(Out{})
+
+func isInStruct(ty reflect.Type) bool {
+	return embeds(ty, inType)
+}
+
+func isOutStruct(ty reflect.Type) bool {
+	return embeds(ty, outType)
+}
+
+// embeds returns true if ty is a struct which embeds the marker type given.
+func embeds(ty reflect.Type, marker reflect.Type) bool {
+	if ty.Kind() != reflect.Struct {
+		return false
+	}
+	for i := 0; i < ty.NumField(); i++ {
+		if field := ty.Field(i); field.Anonymous && field.Type == marker {
+			return true
+		}
+	}
+	return false
+}
+
+// structField is a field of an In or Out struct to be injected or provided.
+type structField struct {
+	index int
+	ty    reflect.Type
+	fieldTag
+}
+
+// structFields returns the fields of an In or Out struct that are to be
+// injected or provided, or an ErrorInjectStruct if any of their tags are
+// not valid.
+func structFields(ty reflect.Type) ([]structField, error) {
+	fields := []structField{}
+	errs := []ErrorField{}
+
+	for i := 0; i < ty.NumField(); i++ {
+		field := ty.Field(i)
+		if field.Anonymous && (field.Type == inType || field.Type == outType) {
+			continue
+		}
+		tag, err := parseFieldTag(field)
+		if err != nil {
+			errs = append(errs, ErrorField{Field: field.Name, Err: err})
+			continue
+		}
+		if tag.skip {
+			continue
+		}
+		fields = append(fields, structField{i, field.Type, tag})
+	}
+
+	if len(errs) > 0 {
+		return nil, ErrorInjectStruct{Ty: ty, Fields: errs}
+	}
+	return fields, nil
+}
+
+// getInStruct provides a struct embedding In, injecting each of its fields.
+func (ctx *Context) getInStruct(r *resolution, ty reflect.Type) (reflect.Value, error) {
+	fields, err := structFields(ty)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+
+	out := reflect.New(ty).Elem()
+	for _, field := range fields {
+		if field.optional && !ctx.provides(field.ty, field.name) {
+			continue
+		}
+		var val reflect.Value
+		if field.name != "" {
+			val, err = ctx.getNamedInjectable(r, field.ty, field.name)
+		} else {
+			val, err = ctx.getInjectable(r, field.ty)
+		}
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		out.Field(field.index).Set(val)
+	}
+	return out, nil
+}
+
+// inStructBindings returns the registrations that asking for the In struct
+// given from this Context would make use of. It mirrors getInStruct.
+func (ctx *Context) inStructBindings(ty reflect.Type) ([]binding, error) {
+	fields, err := structFields(ty)
+	if err != nil {
+		return nil, err
+	}
+
+	out := []binding{}
+	for _, field := range fields {
+		if field.optional && !ctx.provides(field.ty, field.name) {
+			continue
+		}
+		var deps []binding
+		if field.name != "" {
+			key := normalizeKey(field.ty)
+			key.Name = field.name
+			deps, err = ctx.bindingsForKey(key)
+		} else {
+			deps, err = ctx.bindingsFor(field.ty)
+		}
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, deps...)
+	}
+	return out, nil
+}
+
+// provides returns true if asking for ty with the name given would find
+// something registered, without constructing anything.
+func (ctx *Context) provides(ty reflect.Type, name string) bool {
+	var err error
+	if name != "" {
+		key := normalizeKey(ty)
+		key.Name = name
+		_, err = ctx.bindingsForKey(key)
+	} else {
+		_, err = ctx.bindingsFor(ty)
+	}
+	_, missing := err.(ErrorTypeNotRegistered)
+	return !missing
+}
+
+// registerOut registers each field of the Out struct provided by the
+// registration given as a separate type.
+func (ctx *Context) registerOut(structKey injectableKey, structVal *injectableValue, opts registerOptions) {
+	fields, err := structFields(structKey.Ty)
+	if err != nil {
+		panic(err.Error())
+	}
+	if len(fields) == 0 {
+		panic(fmt.Sprintf("The function being registered returns '%s', which has no fields to provide", typeName(structKey.Ty)))
+	}
+
+	// The struct itself is never looked up, but its instance holds the
+	// values of every field, and it owns anything that needs cleaning up:
+	structVal.registeredOn = ctx
+	structVal.module = opts.module
+	structVal.callSite = opts.callSite
+
+	for _, field := range fields {
+		if field.optional {
+			panic(fmt.Sprintf("The field '%s' of '%s' cannot be optional", structKey.Ty.Field(field.index).Name, typeName(structKey.Ty)))
+		}
+
+		key := normalizeKey(field.ty)
+		key.Name = opts.name
+		if field.name != "" {
+			key.Name = field.name
+		}
+
+		field := field
+		ctx.put(key, opts, &injectableValue{
+			lifetime:      structVal.lifetime,
+			params:        structVal.params,
+			fromRequester: structVal.fromRequester,
+			itemMaker: func(r *resolution, requester *Context) (reflect.Value, func() error, error) {
+				item, err := r.construct(requester, structKey, structVal, requester.instanceOf(structVal))
+				if err != nil {
+					return reflect.Value{}, nil, err
+				}
+				return normalizeValue(item.Elem().Field(field.index)), nil, nil
+			},
+		})
+	}
+}
+
+// outCloserFunc returns a function which closes each field of the normalized
+// Out struct given that implements io.Closer, in the reverse order to that in
+// which the fields are declared. If the struct itself implements io.Closer,
+// only its Close method is used. Otherwise, nil is returned if no field needs
+// closing.
+func outCloserFunc(item reflect.Value) func() error {
+	if closer := closerFunc(item); closer != nil {
+		return closer
+	}
+
+	fields, _ := structFields(item.Elem().Type())
+	closers := []func() error{}
+	for _, field := range fields {
+		if closer := closerFunc(normalizeValue(item.Elem().Field(field.index))); closer != nil {
+			closers = append(closers, closer)
+		}
+	}
+	if len(closers) == 0 {
+		return nil
+	}
+
+	return func() error {
+		errs := []error{}
+		for i := len(closers) - 1; i >= 0; i-- {
+			if err := closers[i](); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		return errors.Join(errs...)
+	}
+}
@@ -0,0 +1,116 @@
+package depends
+
+import (
+	"context"
+	"reflect"
+)
+
+// Provider can be asked for in place of some type T in order to put off
+// creating the value of type T until it is actually needed, by calling Get.
+// This avoids creating expensive values on code paths that may not use them,
+// and allows registered functions which depend on each other to be created,
+// so long as at least one of them asks for a Provider rather than the value
+// itself. Asking for a func() (T, error) works in the same way.
+//
+// Injecting a Provider fails if nothing is registered for T, but nothing else
+// about T is checked until Get is called. Each call to Get asks for T from
+// the Context that the Provider was injected from, exactly as though T had
+// been asked for directly, so Singleton functions are only called once, and
+// Transient ones are called every time. Get can be called from any goroutine,
+// but must not be called on another goroutine while the function that the
+// Provider was injected into is waiting on it.
+type Provider[T any] struct {
+	get func(c context.Context) (reflect.Value, error)
+}
+
+// Get returns the value of type T, creating it if need be, or an error
+// describing why it could not be obtained.
+func (p Provider[T]) Get() (T, error) {
+	return p.GetContext(context.Background())
+}
+
+// GetContext returns the value of type T in the same way as Get, but allows
+// the process to be cancelled using c, as with TryInjectContext.
+func (p Provider[T]) GetContext(c context.Context) (T, error) {
+	if p.get == nil {
+		var out T
+		return out, ErrorTypeNotRegistered{Ty: typeOf[T]()}
+	}
+	return valueAs[T](p.get(c))
+}
+
+// MustGet returns the value of type T, creating it if need be. If
+// anything goes wrong, it will panic.
+func (p Provider[T]) MustGet() T {
+	out, err := p.Get()
+	if err != nil {
+		panic(err.Error())
+	}
+	return out
+}
+
+func (Provider[T]) providerOf() reflect.Type {
+	return typeOf[T]()
+}
+
+func (Provider[T]) providerType() reflect.Type {
+	return typeOf[Provider[T]]()
+}
+
+func (Provider[T]) withGet(get func(c context.Context) (reflect.Value, error)) interface{} {
+	return Provider[T]{get}
+}
+
+// providerValue is implemented by every Provider type, and lets us
+// create them without knowing the type parameter.
+type providerValue interface {
+	providerOf() reflect.Type
+	providerType() reflect.Type
+	withGet(get func(c context.Context) (reflect.Value, error)) interface{}
+}
+
+var providerValueType = reflect.TypeOf((*providerValue)(nil)).Elem()
+
+// lazyOf returns the type that asking for ty lazily provides, if ty is
+// a Provider or a func() (T, error). Structs embedding a Provider also
+// have its methods, so aren't mistaken for it.
+func lazyOf(ty reflect.Type) (reflect.Type, bool) {
+	if ty.Kind() == reflect.Struct && ty.Implements(providerValueType) {
+		p := reflect.New(ty).Elem().Interface().(providerValue)
+		if p.providerType() == ty {
+			return p.providerOf(), true
+		}
+	}
+	if ty.Kind() == reflect.Func && ty.NumIn() == 0 && ty.NumOut() == 2 && ty.Out(1) == errorType {
+		return ty.Out(0), true
+	}
+	return nil, false
+}
+
+// getLazy provides a Provider or func() (T, error) which obtains a T from
+// this Context when called.
+func (ctx *Context) getLazy(r *resolution, ty reflect.Type, innerTy reflect.Type) (reflect.Value, error) {
+	if _, err := ctx.bindingsFor(innerTy); err != nil {
+		return reflect.Value{}, err
+	}
+
+	get := func(c context.Context) (reflect.Value, error) {
+		lazy := newResolution(c)
+		lazy.parent = r
+		return ctx.getInjectable(lazy, innerTy)
+	}
+
+	if ty.Kind() == reflect.Struct {
+		p := reflect.New(ty).Elem().Interface().(providerValue)
+		return reflect.ValueOf(p.withGet(get)), nil
+	}
+
+	fn := reflect.MakeFunc(ty, func([]reflect.Value) []reflect.Value {
+		val, err := get(context.Background())
+		if err != nil {
+			val = reflect.Zero(innerTy)
+		}
+		return []reflect.Value{val, reflect.ValueOf(&err).Elem()}
+	})
+	return fn, nil
+}
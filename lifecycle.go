@@ -0,0 +1,195 @@
+package depends
+
+import (
+	"io"
+	"reflect"
+)
+
+// cleanup is something to run when the Context owning some value is closed.
+type cleanup struct {
+	// The type of the value being cleaned up.
+	ty reflect.Type
+	fn func() error
+}
+
+// Close closes the Context, releasing anything that was created by the functions
+// registered on it. Any children of the Context with something to clean up are
+// closed first, most recently attached first. Then, for each value created in this Context that either came
+// with a cleanup function or implements io.Closer, the cleanup is run, in the
+// reverse order to that in which the values were created (so that values are
+// cleaned up before the things they depend on).
+//
+// Values created by Transient functions are not cleaned up, since any number
+// of them may be created; whatever asked for them is responsible for them.
+//
+// Every cleanup is run even if some fail, and any errors are handed back
+// together in an ErrorCloseFailed. Once closed, nothing further can be
+// injected from the Context or any child of it, and closing it again does
+// nothing.
+func (ctx *Context) Close() error {
+	ctx.mu.Lock()
+	if ctx.closed {
+		ctx.mu.Unlock()
+		return nil
+	}
+	ctx.closed = true
+	children := ctx.children
+	cleanups := ctx.cleanups
+	ctx.children = nil
+	ctx.cleanups = nil
+	ctx.mu.Unlock()
+
+	errs := []error{}
+
+	for i := len(children) - 1; i >= 0; i-- {
+		if err := children[i].Close(); err != nil {
+			if closeErr, ok := err.(ErrorCloseFailed); ok {
+				errs = append(errs, closeErr.Errors...)
+			} else {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	for i := len(cleanups) - 1; i >= 0; i-- {
+		if err := cleanups[i].run(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if ctx.parent != nil {
+		ctx.parent.forgetChild(ctx)
+	}
+
+	if len(errs) > 0 {
+		return ErrorCloseFailed{errs}
+	}
+	return nil
+}
+
+func (c cleanup) run() (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = ErrorCleanupFailed{Ty: c.ty, Err: ErrorPanicInFunction{Panic: e}}
+		}
+	}()
+	if err := c.fn(); err != nil {
+		return ErrorCleanupFailed{Ty: c.ty, Err: err}
+	}
+	return nil
+}
+
+// isClosed returns true if the Context or any of its parents has been
+// closed, in which case nothing more can be created from it.
+func (ctx *Context) isClosed() bool {
+	for c := ctx; c != nil; c = c.parent {
+		c.mu.Lock()
+		closed := c.closed
+		c.mu.Unlock()
+		if closed {
+			return true
+		}
+	}
+	return false
+}
+
+// addCleanup records a cleanup function to run when the Context is closed.
+// If the Context has already been closed, the value being cleaned up can't
+// be kept, so fn is run straight away and ErrorContextClosed is returned.
+// fn may be nil if there's nothing to clean up.
+func (ctx *Context) addCleanup(ty reflect.Type, fn func() error) error {
+	ctx.mu.Lock()
+	if ctx.closed {
+		ctx.mu.Unlock()
+		if fn != nil {
+			cleanup{ty, fn}.run()
+		}
+		return ErrorContextClosed{}
+	}
+	if fn == nil {
+		ctx.mu.Unlock()
+		return nil
+	}
+	ctx.cleanups = append(ctx.cleanups, cleanup{ty, fn})
+	ctx.mu.Unlock()
+
+	// Now that there's something to clean up, our parents need to know
+	// about us in order to close us. If one has been closed meanwhile,
+	// we close ourselves instead:
+	if !ctx.attach() {
+		ctx.Close()
+		return ErrorContextClosed{}
+	}
+	return nil
+}
+
+// attach adds the Context to its parent's children, and the parent to its
+// own parent's children and so on, so that closing any of them closes it.
+// It returns false if any of them has already been closed.
+func (ctx *Context) attach() bool {
+	parent := ctx.parent
+	if parent == nil {
+		return true
+	}
+	parent.mu.Lock()
+	if parent.closed {
+		parent.mu.Unlock()
+		return false
+	}
+	if ctx.attached {
+		parent.mu.Unlock()
+		return true
+	}
+	ctx.attached = true
+	parent.children = append(parent.children, ctx)
+	parent.mu.Unlock()
+	return parent.attach()
+}
+
+func (ctx *Context) forgetChild(child *Context) {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	for i, c := range ctx.children {
+		if c == child {
+			ctx.children = append(ctx.children[:i:i], ctx.children[i+1:]...)
+			return
+		}
+	}
+}
+
+// cleanupFunc turns a func() returned from a registered function into
+// a cleanup, or nil if the func is nil.
+func cleanupFunc(val reflect.Value) func() error {
+	if val.IsNil() {
+		return nil
+	}
+	fn := val.Interface().(func())
+	return func() error {
+		fn()
+		return nil
+	}
+}
+
+// closerFunc returns the Close method of the normalized value given if it,
+// or the value that it points to, implements io.Closer. Otherwise, nil is
+// returned.
+func closerFunc(val reflect.Value) func() error {
+	for _, v := range []reflect.Value{val, val.Elem()} {
+		if isNil(v) {
+			return nil
+		}
+		if closer, ok := v.Interface().(io.Closer); ok {
+			return closer.Close
+		}
+	}
+	return nil
+}
+
+func isNil(val reflect.Value) bool {
+	switch val.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan:
+		return val.IsNil()
+	default:
+		return false
+	}
+}
@@ -2,7 +2,17 @@ package depends
 
 import (
 	"reflect"
+	"sort"
 	"sync"
+	"time"
+)
+
+// The construction states that an instance can be in.
+const (
+	stateNotStarted = iota
+	stateInProgress
+	stateDone
+	stateFailed
 )
 
 type syncMap struct {
@@ -10,21 +20,66 @@ type syncMap struct {
 }
 
 type injectableKey struct {
-	Ty reflect.Type
+	Ty   reflect.Type
+	Name string
 }
 
 type injectableValue struct {
 	// If not nil, this is a function that can have
 	// dependencies injected into, and will be called
-	// in order to return the desired thing.
-	itemMaker func(from []reflect.Type) (reflect.Value, error)
+	// in order to return the desired thing, along with a
+	// function to clean it up if there is one. It's handed
+	// the Context that the thing was asked for from.
+	itemMaker func(r *resolution, requester *Context) (reflect.Value, func() error, error)
+	// How long the item returned from itemMaker is
+	// used for before itemMaker is called again.
+	lifetime Lifetime
+	// What to do if the registered function fails. If
+	// nil, the failure is remembered and handed back
+	// every time the item is asked for.
+	retry *RetryPolicy
+	// The argument types of the registered function, if
+	// any, which are asked for when itemMaker is run.
+	params []reflect.Type
+	// The Context that this was registered on, and whether
+	// params are resolved from the Context asking for the
+	// item instead of this one.
+	registeredOn  *Context
+	fromRequester bool
+	// True if this was registered with Decorate, and so wraps
+	// whatever it was registered against.
+	decorator bool
+	// The name of the Module that made the registration, if any.
+	module string
+	// Where the registration was made, as "file:line".
+	callSite string
+	// Increases with each registration, so that we can put
+	// registrations back into the order they were made in.
+	seq uint64
+	// The instance of the item for singletons (and
+	// items that were provided directly). Scoped and
+	// transient items have instances created as needed.
+	instance
+}
+
+// instance holds one copy of a registered item.
+type instance struct {
 	// If not zero, this is the item (either provided
 	// directly or once it's returned from the itemMaker)
 	item reflect.Value
-	// Make sure that the item init only happens once.
-	// This will run itemMaker if not nil and populate
-	// item.
-	init sync.Once
+	// Which construction state we are in, the resolution
+	// running itemMaker while in progress, and a channel
+	// which is closed once it's finished. These are all
+	// guarded by resolveMu.
+	state int
+	owner *resolution
+	done  chan struct{}
+	// If the registered function failed, the error it failed
+	// with, how many times it's failed and when it can next
+	// be retried. These are also guarded by resolveMu.
+	err      error
+	failures int
+	retryAt  time.Time
 }
 
 func (m *syncMap) get(key injectableKey) (*injectableValue, bool) {
@@ -38,3 +93,67 @@ func (m *syncMap) get(key injectableKey) (*injectableValue, bool) {
 func (m *syncMap) put(key injectableKey, val *injectableValue) {
 	m.Store.Store(key, val)
 }
+
+// entry is a single key and value from a syncMap or syncGroups.
+type entry struct {
+	key injectableKey
+	val *injectableValue
+}
+
+// all returns every entry in the map, in the order that they were registered.
+func (m *syncMap) all() []entry {
+	out := []entry{}
+	m.Store.Range(func(key, val interface{}) bool {
+		out = append(out, entry{key.(injectableKey), val.(*injectableValue)})
+		return true
+	})
+	sortEntries(out)
+	return out
+}
+
+// syncGroups holds the contributions registered to each group, in the
+// order that they were registered.
+type syncGroups struct {
+	mu     sync.Mutex
+	groups map[injectableKey][]*injectableValue
+}
+
+func (m *syncGroups) get(key injectableKey) []*injectableValue {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.groups[key]
+}
+
+// all returns every contribution to every group, in the order that they
+// were registered.
+func (m *syncGroups) all() []entry {
+	m.mu.Lock()
+	out := []entry{}
+	for key, vals := range m.groups {
+		for _, val := range vals {
+			out = append(out, entry{key, val})
+		}
+	}
+	m.mu.Unlock()
+	sortEntries(out)
+	return out
+}
+
+func (m *syncGroups) add(key injectableKey, val *injectableValue) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.groups == nil {
+		m.groups = map[injectableKey][]*injectableValue{}
+	}
+	// Copy so that slices handed out by get are never modified:
+	existing := m.groups[key]
+	vals := make([]*injectableValue, 0, len(existing)+1)
+	vals = append(vals, existing...)
+	m.groups[key] = append(vals, val)
+}
+
+func sortEntries(entries []entry) {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].val.seq < entries[j].val.seq
+	})
+}
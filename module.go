@@ -0,0 +1,220 @@
+package depends
+
+// Module is a named, reusable set of registrations, which can be installed into
+// a Context with Install. Modules are made up of ModuleItems, and can include
+// other modules that they depend on. For example:
+//
+//	var Logging = depends.NewModule("logging",
+//		depends.Provides(newLogger),
+//	)
+//
+//	var Database = depends.NewModule("database",
+//		depends.Includes(Logging),
+//		depends.Provides(newDB),
+//		depends.ProvidesWith(newConn, depends.WithLifetime(depends.Scoped)),
+//		depends.Decorates(withQueryLogging),
+//	)
+//
+// Every registration made by a module records its name, which is then
+// mentioned in errors about the registration and shown in the Graph.
+type Module struct {
+	name  string
+	items []ModuleItem
+}
+
+// NewModule creates a Module with the name and items given. Names should be
+// unique, since installing two different modules with the same name into a
+// Context is an error.
+func NewModule(name string, items ...ModuleItem) *Module {
+	if name == "" {
+		panic("A Module must be given a name")
+	}
+	return &Module{name: name, items: items}
+}
+
+// Name returns the name that the Module was created with.
+func (m *Module) Name() string {
+	return m.name
+}
+
+// ModuleItem is some part of a Module: a set of registrations made by Provides,
+// ProvidesWith or Decorates, or other modules included using Includes.
+type ModuleItem struct {
+	register func(ctx *Context, module string)
+	include  []*Module
+	// Where the item was created, and the keys that it will
+	// register single values against.
+	site string
+	keys []injectableKey
+}
+
+// Provides registers each of the items given when the Module is installed, in
+// the same way as Register.
+func Provides(items ...interface{}) ModuleItem {
+	site := callSite()
+	keys := []injectableKey{}
+	for _, item := range items {
+		keys = append(keys, singleKeys(item, registerOptions{})...)
+	}
+	return ModuleItem{site: site, keys: keys, register: func(ctx *Context, module string) {
+		for _, item := range items {
+			ctx.registerOne(item, registerOptions{module: module, callSite: site})
+		}
+	}}
+}
+
+// ProvidesWith registers the item given when the Module is installed, in the
+// same way as RegisterWith.
+func ProvidesWith(item interface{}, opts ...Option) ModuleItem {
+	site := callSite()
+	keys := singleKeys(item, newRegisterOptions(opts))
+	return ModuleItem{site: site, keys: keys, register: func(ctx *Context, module string) {
+		options := newRegisterOptions(opts)
+		options.module = module
+		options.callSite = site
+		ctx.registerOne(item, options)
+	}}
+}
+
+// Decorates registers the decorator given when the Module is installed, in the
+// same way as Decorate. Something must have been registered for it to decorate
+// by then, for instance by an included Module or an earlier item.
+func Decorates(fn interface{}) ModuleItem {
+	site := callSite()
+	return ModuleItem{register: func(ctx *Context, module string) {
+		ctx.decorate(fn, registerOptions{module: module, callSite: site})
+	}}
+}
+
+// Includes installs the modules given along with the Module, before any of its
+// own registrations are made.
+func Includes(mods ...*Module) ModuleItem {
+	return ModuleItem{include: mods}
+}
+
+// Install makes the registrations of each of the modules given, and any that
+// they include, on the Context. A module which has already been installed on
+// the Context or any of its parents (perhaps because it's included by some other
+// module) is not installed again. If a different module with the same name as
+// one of them has already been installed, or appears more than once amongst
+// them, nothing is installed and Install panics. The same goes if the Context's
+// DuplicatePolicy is ErrorOnDuplicates and the modules would register something
+// that is already registered, or register something twice.
+func (ctx *Context) Install(mods ...*Module) {
+	err := ctx.TryInstall(mods...)
+
+	if err != nil {
+		panic(err.Error())
+	}
+}
+
+// TryInstall installs the modules given in the same way as Install, but returns
+// an ErrorDuplicateModule rather than panicking if two different modules share a
+// name, and an ErrorDuplicateRegistration rather than panicking if something
+// would be registered twice.
+func (ctx *Context) TryInstall(mods ...*Module) error {
+	ctx.mu.Lock()
+	plan := moduleInstaller{ctx: ctx, seen: map[string]*Module{}}
+	for _, mod := range mods {
+		if err := plan.add(mod); err != nil {
+			ctx.mu.Unlock()
+			return err
+		}
+	}
+	if err := plan.checkDuplicates(); err != nil {
+		ctx.mu.Unlock()
+		return err
+	}
+	if ctx.modules == nil {
+		ctx.modules = map[string]*Module{}
+	}
+	for _, mod := range plan.order {
+		ctx.modules[mod.name] = mod
+	}
+	ctx.mu.Unlock()
+
+	for _, mod := range plan.order {
+		for _, item := range mod.items {
+			if item.register != nil {
+				item.register(ctx, mod.name)
+			}
+		}
+	}
+	return nil
+}
+
+// moduleInstaller works out which modules need installing, and in which
+// order, so that included modules are installed first.
+type moduleInstaller struct {
+	ctx   *Context
+	seen  map[string]*Module
+	order []*Module
+}
+
+func (p *moduleInstaller) add(mod *Module) error {
+	if existing, ok := p.seen[mod.name]; ok {
+		if existing != mod {
+			return ErrorDuplicateModule{Name: mod.name}
+		}
+		return nil
+	}
+	if existing, ok := p.ctx.installed(mod.name); ok {
+		if existing != mod {
+			return ErrorDuplicateModule{Name: mod.name}
+		}
+		return nil
+	}
+
+	p.seen[mod.name] = mod
+	for _, item := range mod.items {
+		for _, included := range item.include {
+			if err := p.add(included); err != nil {
+				return err
+			}
+		}
+	}
+	p.order = append(p.order, mod)
+	return nil
+}
+
+// checkDuplicates returns an ErrorDuplicateRegistration if installing the
+// modules would register something twice and the Context doesn't allow it.
+// ctx.mu must be held.
+func (p *moduleInstaller) checkDuplicates() error {
+	if p.ctx.duplicates != ErrorOnDuplicates {
+		return nil
+	}
+	sites := map[injectableKey]string{}
+	for _, mod := range p.order {
+		for _, item := range mod.items {
+			for _, key := range item.keys {
+				previous, exists := sites[key]
+				if existing, ok := p.ctx.injectables.get(key); ok && !exists {
+					previous, exists = existing.callSite, true
+				}
+				if exists {
+					return ErrorDuplicateRegistration{Ty: key.Ty, Name: key.Name, Previous: previous, Current: item.site}
+				}
+				sites[key] = item.site
+			}
+		}
+	}
+	return nil
+}
+
+// installed returns the module with the name given if it has been installed
+// on this Context or any of its parents. ctx.mu must be held.
+func (ctx *Context) installed(name string) (*Module, bool) {
+	if mod, ok := ctx.modules[name]; ok {
+		return mod, true
+	}
+	for c := ctx.parent; c != nil; c = c.parent {
+		c.mu.Lock()
+		mod, ok := c.modules[name]
+		c.mu.Unlock()
+		if ok {
+			return mod, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,77 @@
+package depends
+
+import (
+	"reflect"
+)
+
+// Name is implemented by types which are used with Named to pick out a
+// dependency registered with RegisterNamed. The method should always return
+// the same name, and is called on the zero value of the type (so the type
+// should not be a pointer, which would be nil). For example:
+//
+//	type Primary struct{}
+//	func (Primary) DependsName() string { return "primary" }
+type Name interface {
+	DependsName() string
+}
+
+// Named can be asked for in place of some type T in order to inject the
+// value of type T that was registered against the name given by N. For
+// example, asking for a Named[*sql.DB, Primary] will provide the *sql.DB
+// registered with RegisterNamed("primary", ...) in its Value field.
+type Named[T any, N Name] struct {
+	Value T
+}
+
+func (Named[T, N]) namedKey() (reflect.Type, string) {
+	var n N
+	return typeOf[T](), n.DependsName()
+}
+
+func (Named[T, N]) namedType() reflect.Type {
+	return typeOf[Named[T, N]]()
+}
+
+// namedValue is implemented by every Named type, and lets us find out
+// what is being asked for without knowing the type parameters.
+type namedValue interface {
+	namedKey() (reflect.Type, string)
+	namedType() reflect.Type
+}
+
+var namedValueType = reflect.TypeOf((*namedValue)(nil)).Elem()
+
+// isNamedType returns true if ty is a Named type. Structs embedding a
+// Named type also have its methods, so aren't mistaken for it.
+func isNamedType(ty reflect.Type) bool {
+	return ty.Kind() == reflect.Struct && ty.Implements(namedValueType) &&
+		reflect.New(ty).Elem().Interface().(namedValue).namedType() == ty
+}
+
+// namedKeyOf returns the type and name that the Named type given asks for,
+// or an ErrorInvalidName if the name can't be found.
+func namedKeyOf(ty reflect.Type) (innerTy reflect.Type, name string, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = ErrorInvalidName{Ty: ty, Panic: e}
+		}
+	}()
+	innerTy, name = reflect.New(ty).Elem().Interface().(namedValue).namedKey()
+	return innerTy, name, nil
+}
+
+// keyFor returns the key that asking for ty will look up, taking into
+// account any name asked for using Named. If the name can't be found,
+// the key for ty itself is returned along with an error.
+func keyFor(ty reflect.Type) (injectableKey, error) {
+	if isNamedType(ty) {
+		innerTy, name, err := namedKeyOf(ty)
+		if err != nil {
+			return normalizeKey(ty), err
+		}
+		key := normalizeKey(innerTy)
+		key.Name = name
+		return key, nil
+	}
+	return normalizeKey(ty), nil
+}
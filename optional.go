@@ -0,0 +1,72 @@
+package depends
+
+import (
+	"reflect"
+)
+
+// Optional can be asked for in place of some type T in order to inject the
+// value of type T if one has been registered, rather than failing if not. OK
+// is true if the value was provided, and false (leaving Value as the zero
+// value of T) if nothing has been registered for T. For example, asking for an
+// Optional[*Tracer] allows tracing to be turned on by registering a *Tracer.
+//
+// T can be anything that could be asked for directly, including a Named type.
+// Only a missing registration for T itself is ignored; if T is registered but
+// cannot be provided (for instance because its own dependencies are missing),
+// injection fails as normal.
+type Optional[T any] struct {
+	Value T
+	OK    bool
+}
+
+// Get returns the value and whether it was provided.
+func (o Optional[T]) Get() (T, bool) {
+	return o.Value, o.OK
+}
+
+func (Optional[T]) optionalOf() reflect.Type {
+	return typeOf[T]()
+}
+
+func (Optional[T]) optionalType() reflect.Type {
+	return typeOf[Optional[T]]()
+}
+
+// optionalValue is implemented by every Optional type, and lets us find
+// out what is being asked for without knowing the type parameter.
+type optionalValue interface {
+	optionalOf() reflect.Type
+	optionalType() reflect.Type
+}
+
+var optionalValueType = reflect.TypeOf((*optionalValue)(nil)).Elem()
+
+// isOptionalType returns true if ty is an Optional type. Structs embedding
+// an Optional type also have its methods, so aren't mistaken for it.
+func isOptionalType(ty reflect.Type) bool {
+	return ty.Kind() == reflect.Struct && ty.Implements(optionalValueType) &&
+		reflect.New(ty).Elem().Interface().(optionalValue).optionalType() == ty
+}
+
+// optionalOf returns the type that the Optional type given asks for.
+func optionalOf(ty reflect.Type) reflect.Type {
+	return reflect.New(ty).Elem().Interface().(optionalValue).optionalOf()
+}
+
+// getOptional provides an Optional type, filling it in if the
+// type that it asks for has been registered.
+func (ctx *Context) getOptional(r *resolution, ty reflect.Type) (reflect.Value, error) {
+	out := reflect.New(ty).Elem()
+	innerTy := optionalOf(ty)
+	if !ctx.provides(innerTy, "") {
+		return out, nil
+	}
+
+	val, err := ctx.getInjectable(r, innerTy)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	out.Field(0).Set(val)
+	out.Field(1).SetBool(true)
+	return out, nil
+}
@@ -0,0 +1,188 @@
+package depends
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// Lifetime determines how often a function registered to provide some type
+// is called.
+type Lifetime int
+
+const (
+	// Singleton functions are called once, the first time that the type they
+	// provide is asked for from the Context they were registered on or any of
+	// its children. This is the default.
+	Singleton Lifetime = iota
+	// Transient functions are called every time that the type they provide is
+	// asked for. The values they create are not cleaned up when the Context is
+	// closed.
+	Transient
+	// Scoped functions are called once for each Context that the type they
+	// provide is asked for from. A child Context asking for the type will get
+	// its own copy, even if the function was registered on a parent.
+	Scoped
+)
+
+func (l Lifetime) String() string {
+	switch l {
+	case Singleton:
+		return "Singleton"
+	case Transient:
+		return "Transient"
+	case Scoped:
+		return "Scoped"
+	default:
+		return fmt.Sprintf("Lifetime(%d)", int(l))
+	}
+}
+
+// Option tweaks how an item is registered when handed to RegisterWith
+// or Provide.
+type Option func(*registerOptions)
+
+// WithLifetime sets the Lifetime of a registered function. It has no
+// effect on items which are not functions.
+func WithLifetime(lifetime Lifetime) Option {
+	return func(opts *registerOptions) {
+		opts.lifetime = lifetime
+	}
+}
+
+// FromRequester resolves the dependencies of a registered function from
+// the Context that asked for the value it provides, rather than from the
+// Context that it was registered on. This allows a child Context to override
+// some dependency and have values which depend on it created afresh.
+//
+// Since the value provided can then differ from one Context to the next,
+// Singleton functions registered this way behave as if they were Scoped.
+func FromRequester() Option {
+	return func(opts *registerOptions) {
+		opts.fromRequester = true
+	}
+}
+
+// RetryPolicy determines what happens when a registered function fails, either
+// by returning an error or by panicking. Without one, the failure is remembered
+// and the same error is handed back every time the value is asked for.
+//
+// Failures caused by the dependencies of the function (for instance, because
+// they are not registered) are never remembered, and nor are cancellations.
+type RetryPolicy struct {
+	// The maximum number of times to call the function before the
+	// failure is remembered for good. 0 means no limit.
+	MaxAttempts int
+	// How long to wait after a failure before the function will be
+	// called again. Asking for the value before then hands back the
+	// error from the last failure. The wait doubles after each
+	// consecutive failure.
+	Backoff time.Duration
+	// The longest that Backoff is allowed to grow to. 0 means no limit.
+	MaxBackoff time.Duration
+}
+
+// backoff returns how long to wait after the given number of
+// consecutive failures before trying again.
+func (p RetryPolicy) backoff(failures int) time.Duration {
+	wait := p.Backoff
+	for i := 1; i < failures && wait > 0; i++ {
+		wait *= 2
+		if p.MaxBackoff > 0 && wait >= p.MaxBackoff {
+			break
+		}
+	}
+	if p.MaxBackoff > 0 && wait > p.MaxBackoff {
+		wait = p.MaxBackoff
+	}
+	return wait
+}
+
+// canRetry returns true if a function that has failed the given
+// number of times can be called again at the time given.
+func (p *RetryPolicy) canRetry(failures int, retryAt time.Time, now time.Time) bool {
+	if p == nil {
+		return false
+	}
+	if p.MaxAttempts > 0 && failures >= p.MaxAttempts {
+		return false
+	}
+	return !now.Before(retryAt)
+}
+
+// WithRetry sets the RetryPolicy of a registered function, allowing it to be
+// called again after failing. It has no effect on items which are not functions.
+func WithRetry(policy RetryPolicy) Option {
+	return func(opts *registerOptions) {
+		opts.retry = &policy
+	}
+}
+
+// WithName registers the item against the name given, in the same
+// way as RegisterNamed.
+func WithName(name string) Option {
+	return func(opts *registerOptions) {
+		opts.name = name
+	}
+}
+
+// InGroup registers the item as a contribution to a group, in the
+// same way as RegisterGroup.
+func InGroup() Option {
+	return func(opts *registerOptions) {
+		opts.group = true
+	}
+}
+
+// As registers the item against the interface type pointed to by
+// iface, in the same way as RegisterAs.
+func As(iface interface{}) Option {
+	ifaceTy := interfaceType(iface)
+	return func(opts *registerOptions) {
+		opts.asTy = ifaceTy
+	}
+}
+
+// registerOptions tweak how a single item is registered.
+type registerOptions struct {
+	// If not nil, register the item against this type rather than its own.
+	asTy reflect.Type
+	// The name to register the item against, if any.
+	name string
+	// Add the item to a group rather than replacing any existing item.
+	group bool
+	// How often a registered function is called.
+	lifetime Lifetime
+	// Resolve the dependencies of a registered function from the
+	// Context asking for its value rather than the registering one.
+	fromRequester bool
+	// What to do if a registered function fails.
+	retry *RetryPolicy
+	// The function decorates whatever is registered against
+	// the type of its first argument.
+	decorate bool
+	// The name of the Module making the registration, if any.
+	module string
+	// Where the registration was made, as "file:line".
+	callSite string
+	// Replace any existing registration, whatever the DuplicatePolicy.
+	replace bool
+}
+
+func newRegisterOptions(opts []Option) registerOptions {
+	out := registerOptions{}
+	for _, opt := range opts {
+		opt(&out)
+	}
+	return out
+}
+
+// interfaceType returns the interface type that iface points to, panicking
+// if iface is not a pointer to an interface.
+func interfaceType(iface interface{}) reflect.Type {
+	ifacePtrTy := reflect.TypeOf(iface)
+	if ifacePtrTy == nil || ifacePtrTy.Kind() != reflect.Ptr || ifacePtrTy.Elem().Kind() != reflect.Interface {
+		panic("Expected a nil pointer to the interface type to register against, for example (*io.Reader)(nil)")
+	}
+	return ifacePtrTy.Elem()
+}
@@ -0,0 +1,315 @@
+package depends
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// resolveMu guards the construction state of every injectableValue, as
+// well as the chain and waitingOn fields of every resolution. Holding a
+// single lock for this means that we can always take a consistent look
+// at who is waiting on what, and so spot cycles rather than deadlocking.
+var resolveMu sync.Mutex
+
+// resolution tracks a single request for dependencies, from the call to
+// TryInject (or similar) down through any registered functions that need
+// running in order to satisfy it. It is only ever used by one goroutine
+// at a time.
+type resolution struct {
+	// Handed to anything asking for a context.Context, and
+	// used to abandon the resolution early.
+	goCtx context.Context
+	// The values currently being constructed on behalf of this
+	// resolution, outermost first.
+	chain []resolutionStep
+	// If not nil, the instance that this resolution is currently
+	// waiting on some other resolution to finish constructing.
+	waitingOn *instance
+	// If not nil, the resolution that handed out the Provider
+	// (or similar) that this resolution was started from.
+	parent *resolution
+}
+
+type resolutionStep struct {
+	key   injectableKey
+	value *injectableValue
+	inst  *instance
+}
+
+// newResolution creates a resolution which can be cancelled using
+// the context.Context given. If nil, context.Background() is used.
+func newResolution(c context.Context) *resolution {
+	if c == nil {
+		c = context.Background()
+	}
+	return &resolution{goCtx: c}
+}
+
+// canceled returns an ErrorCanceled if the resolution's
+// context.Context is done, or nil otherwise.
+func (r *resolution) canceled() error {
+	if err := r.goCtx.Err(); err != nil {
+		return ErrorCanceled{Chain: r.types(), Err: err}
+	}
+	return nil
+}
+
+// types returns the types in the chain of values being constructed.
+func (r *resolution) types() []reflect.Type {
+	out := make([]reflect.Type, 0, len(r.chain))
+	for _, step := range r.chain {
+		out = append(out, step.key.Ty)
+	}
+	return out
+}
+
+// allSteps returns the chain of values being constructed, including those
+// still being constructed by any parent resolutions. resolveMu must be held.
+func (r *resolution) allSteps() []resolutionStep {
+	var out []resolutionStep
+	if r.parent != nil {
+		out = r.parent.allSteps()
+	}
+	return append(out, r.chain...)
+}
+
+// within returns true if other is this resolution or one of its parents.
+// A parent may well be blocked waiting for this resolution to finish, so
+// we must never wait on anything that it is constructing.
+func (r *resolution) within(other *resolution) bool {
+	for ; r != nil; r = r.parent {
+		if r == other {
+			return true
+		}
+	}
+	return false
+}
+
+// construct obtains the item for the instance of the injectableValue given, running
+// its itemMaker if it has not already been run. requester is the Context that the
+// item was asked for from. If some other resolution is busy
+// constructing the same instance, we wait for it to finish, unless doing so would
+// lead to a deadlock, in which case we have found a cycle and return ErrorCircularInject.
+func (r *resolution) construct(requester *Context, key injectableKey, arg *injectableValue, inst *instance) (reflect.Value, error) {
+
+	resolveMu.Lock()
+	for {
+		switch inst.state {
+
+		case stateDone:
+			resolveMu.Unlock()
+			return inst.item, nil
+
+		case stateFailed:
+			if !arg.retry.canRetry(inst.failures, inst.retryAt, time.Now()) {
+				err := inst.err
+				resolveMu.Unlock()
+				return reflect.Value{}, err
+			}
+			inst.state = stateNotStarted
+
+		case stateInProgress:
+			if chain, isCycle := r.cycleThrough(resolutionStep{key, arg, inst}); isCycle {
+				resolveMu.Unlock()
+				return reflect.Value{}, circularError(chain)
+			}
+			if err := r.canceled(); err != nil {
+				resolveMu.Unlock()
+				return reflect.Value{}, err
+			}
+			done := inst.done
+			r.waitingOn = inst
+			resolveMu.Unlock()
+			select {
+			case <-done:
+			case <-r.goCtx.Done():
+			}
+			resolveMu.Lock()
+			r.waitingOn = nil
+
+		default:
+			if err := r.canceled(); err != nil {
+				resolveMu.Unlock()
+				return reflect.Value{}, err
+			}
+			// We (or a parent) may already be constructing some other
+			// instance of the same registration, which would also be
+			// a cycle:
+			if r.constructing(arg) {
+				chain := append(r.allSteps(), resolutionStep{key, arg, inst})
+				resolveMu.Unlock()
+				return reflect.Value{}, circularError(chain)
+			}
+			inst.state = stateInProgress
+			inst.owner = r
+			inst.done = make(chan struct{})
+			r.chain = append(r.chain, resolutionStep{key, arg, inst})
+			resolveMu.Unlock()
+			return r.runItemMaker(requester, key, arg, inst)
+
+		}
+	}
+
+}
+
+// runItemMaker runs the itemMaker for an instance which this resolution has
+// claimed, and then records the outcome and wakes up anything waiting on it.
+// If the resolution was cancelled while the itemMaker was running, the item
+// is cleaned up and thrown away rather than being kept.
+func (r *resolution) runItemMaker(requester *Context, key injectableKey, arg *injectableValue, inst *instance) (item reflect.Value, err error) {
+
+	// finished is false if the itemMaker panicked, and factoryFailed is
+	// true if the registered function itself failed (as opposed to one of
+	// its dependencies), in which case the failure is remembered.
+	finished := false
+	factoryFailed := false
+
+	// Make sure we always release the instance, even if something panics,
+	// so that nothing is left waiting on it forever.
+	defer func() {
+		resolveMu.Lock()
+		r.chain = r.chain[:len(r.chain)-1]
+		switch {
+		case finished && err == nil:
+			inst.item = item
+			inst.err = nil
+			inst.failures = 0
+			inst.state = stateDone
+		case finished && factoryFailed:
+			inst.err = err
+			inst.failures++
+			if arg.retry != nil {
+				inst.retryAt = time.Now().Add(arg.retry.backoff(inst.failures))
+			}
+			inst.state = stateFailed
+		default:
+			inst.state = stateNotStarted
+		}
+		inst.owner = nil
+		close(inst.done)
+		resolveMu.Unlock()
+	}()
+
+	item, cleanupFn, err := arg.itemMaker(r, requester)
+	finished = true
+	if fErr, ok := err.(factoryError); ok {
+		factoryFailed = true
+		err = fErr.error
+	}
+
+	// If we were cancelled, that's likely why things went wrong, and
+	// anything that was created is thrown away:
+	if _, ok := err.(ErrorCanceled); !ok {
+		if cancelErr := r.canceled(); cancelErr != nil {
+			if err == nil && cleanupFn != nil {
+				cleanup{key.Ty, cleanupFn}.run()
+			}
+			factoryFailed = false
+			return reflect.Value{}, cancelErr
+		}
+	}
+	if err != nil {
+		return reflect.Value{}, err
+	}
+
+	// Singletons belong to the Context they were registered on, and
+	// Scoped values to the Context that asked for them. Transient values
+	// belong to whatever asked for them, so aren't kept track of:
+	owner := arg.registeredOn
+	switch arg.lifetime {
+	case Transient:
+		return item, nil
+	case Scoped:
+		owner = requester
+	}
+	if err := owner.addCleanup(key.Ty, cleanupFn); err != nil {
+		return reflect.Value{}, err
+	}
+
+	return item, nil
+
+}
+
+// cycleThrough checks whether waiting on the instance in the step given (which
+// must be in progress) would lead back to this resolution, either because we are
+// constructing it ourselves or because whoever is constructing it is waiting
+// (perhaps indirectly) on us. If so, the full chain of steps making up the cycle
+// is returned. resolveMu must be held.
+func (r *resolution) cycleThrough(want resolutionStep) ([]resolutionStep, bool) {
+
+	chain := append(r.allSteps(), want)
+	inst := want.inst
+
+	for owner := inst.owner; owner != nil; {
+
+		if r.within(owner) {
+			return chain, true
+		}
+
+		// Follow the owner's chain onwards from the instance we want:
+		chain = append(chain, owner.chain[owner.indexOfInstance(inst)+1:]...)
+
+		// The owner isn't blocked on anything, so will finish eventually:
+		next := owner.waitingOn
+		if next == nil || next.state != stateInProgress {
+			return nil, false
+		}
+
+		nextOwner := next.owner
+		chain = append(chain, nextOwner.chain[nextOwner.indexOfInstance(next)])
+		inst, owner = next, nextOwner
+
+	}
+
+	return nil, false
+
+}
+
+// circularError describes the cycle made up of the steps given.
+func circularError(chain []resolutionStep) ErrorCircularInject {
+	err := ErrorCircularInject{}
+	for _, step := range chain {
+		err.Chain = append(err.Chain, step.key.Ty)
+		err.RegisteredAt = append(err.RegisteredAt, step.value.callSite)
+		err.Modules = append(err.Modules, step.value.module)
+	}
+	return err
+}
+
+// constructing returns true if this resolution or any of its parents
+// is constructing an instance of the registration given.
+func (r *resolution) constructing(arg *injectableValue) bool {
+	for ; r != nil; r = r.parent {
+		if r.indexOf(arg) >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *resolution) indexOf(arg *injectableValue) int {
+	for i, step := range r.chain {
+		if step.value == arg {
+			return i
+		}
+	}
+	return -1
+}
+
+func (r *resolution) indexOfInstance(inst *instance) int {
+	for i, step := range r.chain {
+		if step.inst == inst {
+			return i
+		}
+	}
+	return -1
+}
+
+// factoryError is returned from an itemMaker when the registered function
+// itself failed, rather than something it depends on, so that we know to
+// remember the failure.
+type factoryError struct {
+	error
+}
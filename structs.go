@@ -0,0 +1,116 @@
+package depends
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// InjectStruct fills in the exported fields of the struct pointed to by ptr with
+// values from the Context, by asking for each field's type as though it were
+// an argument handed to Inject. If anything goes wrong, it will panic.
+//
+// Fields can be tweaked with a `depends` tag. `depends:"-"` leaves the field
+// alone, `depends:"name=primary"` asks for the value registered with the
+// name "primary" (see RegisterNamed), and `depends:"optional"` leaves the field
+// alone if nothing has been registered for it. Options can be combined, for
+// example `depends:"name=primary,optional"`.
+func (ctx *Context) InjectStruct(ptr interface{}) {
+	err := ctx.TryInjectStruct(ptr)
+
+	if err != nil {
+		panic(err.Error())
+	}
+}
+
+// TryInjectStruct fills in the exported fields of the struct pointed to by ptr
+// in the same way as InjectStruct. If any field cannot be filled in, the struct
+// is left untouched and an ErrorInjectStruct is returned, describing every field
+// that could not be.
+func (ctx *Context) TryInjectStruct(ptr interface{}) error {
+	ptrVal := reflect.ValueOf(ptr)
+	if ptrVal.Kind() != reflect.Ptr || ptrVal.IsNil() || ptrVal.Elem().Kind() != reflect.Struct {
+		return ErrorStructNotProvided{}
+	}
+	structVal := ptrVal.Elem()
+	structTy := structVal.Type()
+
+	r := newResolution(nil)
+	vals := make([]reflect.Value, structTy.NumField())
+	errs := []ErrorField{}
+
+	for i := 0; i < structTy.NumField(); i++ {
+		field := structTy.Field(i)
+		tag, err := parseFieldTag(field)
+		if err != nil {
+			errs = append(errs, ErrorField{Field: field.Name, Err: err})
+			continue
+		}
+		if tag.skip || (tag.optional && !ctx.provides(field.Type, tag.name)) {
+			continue
+		}
+
+		var val reflect.Value
+		if tag.name != "" {
+			val, err = ctx.getNamedInjectable(r, field.Type, tag.name)
+		} else {
+			val, err = ctx.getInjectable(r, field.Type)
+		}
+		if err != nil {
+			errs = append(errs, ErrorField{Field: field.Name, Err: err})
+			continue
+		}
+		vals[i] = val
+	}
+
+	if len(errs) > 0 {
+		return ErrorInjectStruct{Ty: structTy, Fields: errs}
+	}
+
+	for i, val := range vals {
+		if val.IsValid() {
+			structVal.Field(i).Set(val)
+		}
+	}
+	return nil
+}
+
+// fieldTag describes how a struct field should be injected.
+type fieldTag struct {
+	// Don't inject the field at all.
+	skip bool
+	// The name to ask for the field's type with, if any.
+	name string
+	// Leave the field alone if its type has not been registered.
+	optional bool
+}
+
+// parseFieldTag works out how to inject the field given from its `depends`
+// tag, returning an error if the tag is not valid.
+func parseFieldTag(field reflect.StructField) (fieldTag, error) {
+	out := fieldTag{}
+
+	tag, hasTag := field.Tag.Lookup("depends")
+	if field.PkgPath != "" || tag == "-" {
+		out.skip = true
+		return out, nil
+	}
+	if !hasTag {
+		return out, nil
+	}
+
+	for _, part := range strings.Split(tag, ",") {
+		part = strings.TrimSpace(part)
+		switch {
+		case part == "":
+		case strings.HasPrefix(part, "name="):
+			out.name = strings.TrimPrefix(part, "name=")
+		case part == "optional":
+			out.optional = true
+		default:
+			return out, fmt.Errorf("unknown option '%s' in depends tag", part)
+		}
+	}
+
+	return out, nil
+}
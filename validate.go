@@ -0,0 +1,327 @@
+package depends
+
+import (
+	"reflect"
+)
+
+// Validate checks that every function registered on the Context, or on any of its
+// parents, could have its arguments injected, without calling any of them. This
+// allows missing registrations to be caught early (for instance at startup or in
+// a unit test) rather than when some rarely-run code asks for them.
+//
+// Every problem found is reported in a single ErrorValidation. This includes
+// arguments which have not been registered (as ErrorUnsatisfiedDependency),
+// registered functions which depend on themselves (as ErrorCircularInject) and
+// types which are registered in more than one conflicting way (as
+// ErrorAmbiguousBinding).
+func (ctx *Context) Validate() error {
+	v := validator{state: map[validatorNode]int{}}
+
+	for _, c := range ctx.lineage() {
+		for _, e := range c.all() {
+			v.visit(ctx.bindingOf(e.key, e.val))
+		}
+	}
+	for len(v.lazy) > 0 {
+		b := v.lazy[0]
+		v.lazy = v.lazy[1:]
+		b.lazy = false
+		v.visit(b)
+	}
+	v.checkAmbiguous(ctx)
+
+	if len(v.errs) > 0 {
+		return ErrorValidation{v.errs}
+	}
+	return nil
+}
+
+// CanInject checks whether the function provided could have its arguments
+// injected from the Context, without calling it or any registered functions.
+// It returns nil if so, or else the error that TryInject would (currently)
+// return, for example an ErrorTypeNotRegistered noting which argument could
+// not be provided.
+func (ctx *Context) CanInject(fn interface{}) error {
+	fnTy := reflect.TypeOf(fn)
+	if fnTy == nil || fnTy.Kind() != reflect.Func {
+		return ErrorFunctionNotProvided{}
+	}
+	c := checker{checked: map[*injectableValue]bool{}}
+	fnArg := &injectableValue{params: funcParams(fnTy)}
+	return c.checkParams(binding{arg: fnArg, requester: ctx, from: ctx})
+}
+
+// Check runs CanInject on each of the functions provided, and returns an
+// ErrorValidation containing an ErrorCannotInject for each one that could
+// not have its arguments injected, or nil if they all could.
+func (ctx *Context) Check(fns ...interface{}) error {
+	errs := []error{}
+	for i, fn := range fns {
+		if err := ctx.CanInject(fn); err != nil {
+			errs = append(errs, ErrorCannotInject{Index: i, Fn: reflect.TypeOf(fn), Err: err})
+		}
+	}
+	if len(errs) > 0 {
+		return ErrorValidation{errs}
+	}
+	return nil
+}
+
+// checker walks the registrations needed to inject into some function
+// in the same order that TryInject would, stopping at the first problem.
+type checker struct {
+	stack   []binding
+	checked map[*injectableValue]bool
+}
+
+func (c *checker) checkParams(b binding) error {
+	for i, param := range b.arg.params {
+		deps, err := b.paramBindings(i, param)
+		if err != nil {
+			if e, ok := err.(ErrorTypeNotRegistered); ok {
+				e.Pos = i + 1
+				err = e.requiredBy(b.arg.callSite, b.arg.module, i+1)
+			}
+			return err
+		}
+		for _, dep := range deps {
+			if err := c.check(dep); err != nil {
+				// As with TryInject, the position is that of our own argument:
+				if e, ok := err.(ErrorTypeNotRegistered); ok {
+					e.Pos = i + 1
+					err = e
+				}
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (c *checker) check(b binding) error {
+	if b.lazy || c.checked[b.arg] || b.constructed() {
+		return nil
+	}
+	for _, sb := range c.stack {
+		if sb.arg == b.arg {
+			return bindingCycle(append(c.stack, b))
+		}
+	}
+
+	c.stack = append(c.stack, b)
+	err := c.checkParams(b)
+	c.stack = c.stack[:len(c.stack)-1]
+
+	c.checked[b.arg] = err == nil
+	return err
+}
+
+// bindingCycle describes the cycle made up of the bindings given.
+func bindingCycle(chain []binding) ErrorCircularInject {
+	err := ErrorCircularInject{}
+	for _, b := range chain {
+		err.Chain = append(err.Chain, b.key.Ty)
+		err.RegisteredAt = append(err.RegisteredAt, b.arg.callSite)
+		err.Modules = append(err.Modules, b.arg.module)
+	}
+	return err
+}
+
+// binding is a registration as seen from some Context.
+type binding struct {
+	key injectableKey
+	arg *injectableValue
+	// The Context that the registration is asked for from.
+	requester *Context
+	// The Context that the registration's own dependencies
+	// will be resolved from.
+	from *Context
+	// True if the registration is asked for using a Provider (or
+	// similar), and so won't be constructed up front.
+	lazy bool
+}
+
+// bindingOf describes the registration given as it would be used when asked
+// for from this Context.
+func (ctx *Context) bindingOf(key injectableKey, arg *injectableValue) binding {
+	from := arg.registeredOn
+	if arg.fromRequester {
+		from = ctx
+	}
+	return binding{key: key, arg: arg, requester: ctx, from: from}
+}
+
+// paramBindings returns the registrations that the argument at index i of
+// the registered function would make use of. Decorators are handed whatever
+// they decorate as their first argument, rather than it being looked up.
+func (b binding) paramBindings(i int, param reflect.Type) ([]binding, error) {
+	if i == 0 && b.arg.decorator {
+		inner, ok := b.arg.decorated(b.key)
+		if !ok {
+			return nil, ErrorTypeNotRegistered{Ty: b.key.Ty, Name: b.key.Name}
+		}
+		return []binding{b.requester.bindingOf(b.key, inner)}, nil
+	}
+	return b.from.bindingsFor(param)
+}
+
+// constructed returns true if the instance of the registration that would be
+// used has already been created, so that its dependencies won't be asked for.
+func (b binding) constructed() bool {
+	var inst *instance
+	switch b.arg.lifetime {
+	case Transient:
+		return false
+	case Scoped:
+		scoped, ok := b.requester.scoped.Load(b.arg)
+		if !ok {
+			return false
+		}
+		inst = scoped.(*instance)
+	default:
+		inst = &b.arg.instance
+	}
+	resolveMu.Lock()
+	defer resolveMu.Unlock()
+	return inst.state == stateDone
+}
+
+// bindingsFor returns the registrations that asking for ty from this Context
+// would make use of, without constructing anything. It mirrors getInjectable.
+func (ctx *Context) bindingsFor(ty reflect.Type) ([]binding, error) {
+	if ty == contextType {
+		return nil, nil
+	}
+	if innerTy, ok := lazyOf(ty); ok {
+		if _, found := ctx.lookup(normalizeKey(ty)); !found {
+			deps, err := ctx.bindingsFor(innerTy)
+			for i := range deps {
+				deps[i].lazy = true
+			}
+			return deps, err
+		}
+	}
+	if isOptionalType(ty) {
+		innerTy := optionalOf(ty)
+		if !ctx.provides(innerTy, "") {
+			return nil, nil
+		}
+		return ctx.bindingsFor(innerTy)
+	}
+	if isInStruct(ty) {
+		return ctx.inStructBindings(ty)
+	}
+	key, err := keyFor(ty)
+	if err != nil {
+		return nil, err
+	}
+	return ctx.bindingsForKey(key)
+}
+
+// bindingsForKey returns the registrations that looking up the key
+// given from this Context would make use of.
+func (ctx *Context) bindingsForKey(key injectableKey) ([]binding, error) {
+	if arg, ok := ctx.lookup(key); ok {
+		return []binding{ctx.bindingOf(key, arg)}, nil
+	}
+
+	if key.Ty.Kind() == reflect.Slice {
+		elemKey := normalizeKey(key.Ty.Elem())
+		elemKey.Name = key.Name
+		out := []binding{}
+		for _, arg := range ctx.groupMembers(elemKey) {
+			out = append(out, ctx.bindingOf(elemKey, arg))
+		}
+		if len(out) > 0 {
+			return out, nil
+		}
+	}
+
+	return nil, ErrorTypeNotRegistered{Ty: key.Ty, Name: key.Name}
+}
+
+// The states a validatorNode can be in while walking the graph.
+const (
+	nodeUnvisited = iota
+	nodeVisiting
+	nodeVisited
+)
+
+type validatorNode struct {
+	arg  *injectableValue
+	from *Context
+}
+
+// validator walks the graph of registrations, depth first,
+// collecting any problems it finds.
+type validator struct {
+	errs  []error
+	state map[validatorNode]int
+	stack []binding
+	// Dependencies asked for lazily, which are visited separately
+	// since they won't be constructed along with whatever asks for
+	// them, and so can't be part of a cycle.
+	lazy []binding
+}
+
+func (v *validator) visit(b binding) {
+	node := validatorNode{b.arg, b.from}
+
+	switch v.state[node] {
+	case nodeVisited:
+		return
+	case nodeVisiting:
+		var chain []binding
+		for i := len(v.stack) - 1; i >= 0; i-- {
+			if v.stack[i].arg == b.arg && v.stack[i].from == b.from {
+				chain = append(chain, v.stack[i:]...)
+				break
+			}
+		}
+		v.errs = append(v.errs, bindingCycle(append(chain, b)))
+		return
+	}
+
+	v.state[node] = nodeVisiting
+	v.stack = append(v.stack, b)
+
+	for i, param := range b.arg.params {
+		deps, err := b.paramBindings(i, param)
+		if err != nil {
+			if e, ok := err.(ErrorTypeNotRegistered); ok {
+				err = e.requiredBy(b.arg.callSite, b.arg.module, i+1)
+			}
+			v.errs = append(v.errs, ErrorUnsatisfiedDependency{Ty: b.key.Ty, Name: b.key.Name, Module: b.arg.module, Err: err})
+			continue
+		}
+		for _, dep := range deps {
+			if dep.lazy {
+				v.lazy = append(v.lazy, dep)
+				continue
+			}
+			v.visit(dep)
+		}
+	}
+
+	v.stack = v.stack[:len(v.stack)-1]
+	v.state[node] = nodeVisited
+}
+
+// checkAmbiguous looks for slice types which have been registered directly
+// while also having a group of their element type, since the group will then
+// never be used.
+func (v *validator) checkAmbiguous(ctx *Context) {
+	seen := map[injectableKey]bool{}
+	for _, c := range ctx.lineage() {
+		for _, e := range c.groups.all() {
+			sliceKey := injectableKey{Ty: reflect.SliceOf(e.key.Ty), Name: e.key.Name}
+			if seen[sliceKey] {
+				continue
+			}
+			seen[sliceKey] = true
+			if _, ok := ctx.lookup(sliceKey); ok {
+				v.errs = append(v.errs, ErrorAmbiguousBinding{Ty: sliceKey.Ty, Name: sliceKey.Name})
+			}
+		}
+	}
+}